@@ -0,0 +1,134 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// upper bound for request bodies of the JSON API
+const maxAPIBodySize = 4096
+
+// effective password policy as returned by the JSON API
+//
+// only settings the user needs to pick a password are included,
+// paths of blocklists or the breach index stay private
+type policyResponse struct {
+	Domain      string `json:"domain"`
+	MinLength   int    `json:"min_length"`
+	MaxLength   int    `json:"max_length"`
+	MaxBytes    int    `json:"max_bytes,omitempty"`
+	LowerCase   bool   `json:"lower_case"`
+	UpperCase   bool   `json:"upper_case"`
+	Digits      bool   `json:"digits"`
+	SpecialChar bool   `json:"special_char"`
+	MinScore    int    `json:"min_score"`
+	Blocklist   bool   `json:"blocklist"`
+	Breached    bool   `json:"breached"`
+	History     int    `json:"history"`
+	Similarity  struct {
+		MinDistance    int  `json:"min_distance"`
+		MaxSharedAffix int  `json:"max_shared_affix"`
+		DigitsOnly     bool `json:"digits_only"`
+	} `json:"similarity"`
+}
+
+type policyCheckRequest struct {
+	Password string `json:"password"`
+}
+
+type policyCheckResponse struct {
+	Valid bool         `json:"valid"`
+	Rules []policyRule `json:"rules"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+		log.Print("ERROR: cannot encode JSON response")
+	}
+}
+
+// returns the effective password policy for the domain query parameter
+func policyAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	domain := r.URL.Query().Get("domain")
+	policy := domainConfig(domain).PasswordPolicy
+
+	response := policyResponse{
+		Domain:      domain,
+		MinLength:   policy.MinLength,
+		MaxLength:   policy.MaxLength,
+		MaxBytes:    passwordByteLimits[configuredScheme()],
+		LowerCase:   policy.LowerCase,
+		UpperCase:   policy.UpperCase,
+		Digits:      policy.Digits,
+		SpecialChar: policy.SepcialChar,
+		MinScore:    policy.MinScore,
+		Blocklist:   len(policy.Blocklist.Files) > 0,
+		Breached:    policy.Breached.Index != "",
+		History:     policy.History.Count,
+	}
+	response.Similarity.MinDistance = policy.Similarity.MinDistance
+	response.Similarity.MaxSharedAffix = policy.Similarity.MaxSharedAffix
+	response.Similarity.DigitsOnly = policy.Similarity.DigitsOnly
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// evaluates a password against the policy of the session's domain
+//
+// Only rules that don't need account data are checked, so the
+// response doesn't reveal anything about the current or previous
+// passwords. A session is required since the checks are expensive.
+func policyCheckAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, s, ok := sessionFromRequest(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request policyCheckRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	rules := evaluatePasswordPolicy(request.Password, s.Username, s.Domain)
+	response := policyCheckResponse{Valid: true, Rules: rules}
+	for _, rule := range rules {
+		if !rule.Passed {
+			response.Valid = false
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolicyAPIHandler(t *testing.T) {
+	cfg.PasswordPolicy.MinLength = 12
+	cfg.PasswordPolicy.Digits = true
+	cfg.PasswordPolicy.Breached.Index = "/var/lib/pwch/breach.idx"
+
+	override := defaultDomainSettings(&cfg)
+	override.PasswordPolicy.MinLength = 20
+	cfg.domainSettings = map[string]domainSettings{"customer.example": override}
+
+	getPolicy := func(t testing.TB, domain string) (policyResponse, string) {
+		t.Helper()
+
+		req, err := http.NewRequest("GET", "/api/policy?domain="+domain, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		policyAPIHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var policy policyResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &policy); err != nil {
+			t.Fatal(err)
+		}
+		return policy, rr.Body.String()
+	}
+
+	// Test case 1
+	t.Run("global policy", func(t *testing.T) {
+		policy, body := getPolicy(t, "localdomain")
+		if policy.MinLength != 12 || !policy.Digits || !policy.Breached {
+			t.Errorf("Unexpected policy: %+v", policy)
+		}
+		if strings.Contains(body, "breach.idx") {
+			t.Error("Expected breach index path not to be exposed")
+		}
+	})
+
+	// Test case 2
+	t.Run("domain override", func(t *testing.T) {
+		policy, _ := getPolicy(t, "customer.example")
+		if policy.MinLength != 20 {
+			t.Errorf("Expected min length 20, but got: %d", policy.MinLength)
+		}
+	})
+
+	// Test case 3
+	t.Run("method not allowed", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/api/policy", nil)
+		rr := httptest.NewRecorder()
+		policyAPIHandler(rr, req)
+
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected status code %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+		}
+	})
+
+	cfg.domainSettings = nil
+	cfg.PasswordPolicy.Breached.Index = ""
+}
+
+func TestPolicyCheckAPIHandler(t *testing.T) {
+	cfg.PasswordPolicy.MinLength = 12
+	cfg.PasswordPolicy.MaxLength = 64
+	cfg.PasswordPolicy.LowerCase = true
+	cfg.PasswordPolicy.UpperCase = true
+	cfg.PasswordPolicy.Digits = true
+	cfg.PasswordPolicy.SepcialChar = true
+
+	sessionID := newTestSession(t, "alice", "localdomain")
+
+	checkPassword := func(t testing.TB, body string, expectedCode int) policyCheckResponse {
+		t.Helper()
+
+		req, err := http.NewRequest("POST", "/api/policy/check", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionID})
+		rr := httptest.NewRecorder()
+		policyCheckAPIHandler(rr, req)
+
+		if rr.Code != expectedCode {
+			t.Fatalf("expected status code %d, got %d", expectedCode, rr.Code)
+		}
+
+		var response policyCheckResponse
+		if expectedCode == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response
+	}
+
+	// Test case 1
+	t.Run("valid password", func(t *testing.T) {
+		response := checkPassword(t, `{"password": "StrongPassword123!"}`, http.StatusOK)
+		if !response.Valid {
+			t.Errorf("Expected valid password, but got: %+v", response.Rules)
+		}
+	})
+
+	// Test case 2
+	t.Run("per rule results", func(t *testing.T) {
+		response := checkPassword(t, `{"password": "alice1"}`, http.StatusOK)
+		if response.Valid {
+			t.Error("Expected invalid password")
+		}
+
+		failed := make(map[string]bool)
+		for _, rule := range response.Rules {
+			if !rule.Passed {
+				failed[rule.Name] = true
+			}
+		}
+		for _, name := range []string{"min_length", "upper_case", "special_char", "context_words"} {
+			if !failed[name] {
+				t.Errorf("Expected rule %s to fail, but got: %+v", name, response.Rules)
+			}
+		}
+		if failed["lower_case"] || failed["digits"] {
+			t.Errorf("Expected lower_case and digits to pass, but got: %+v", response.Rules)
+		}
+	})
+
+	// Test case 3
+	t.Run("invalid request", func(t *testing.T) {
+		checkPassword(t, `password=secret`, http.StatusBadRequest)
+	})
+
+	// Test case 4
+	t.Run("password exceeding maximum length", func(t *testing.T) {
+		response := checkPassword(t, `{"password": "`+strings.Repeat("Ab1!", 1000)+`"}`, http.StatusOK)
+		if response.Valid || response.Rules[1].Name != "max_length" || response.Rules[1].Passed {
+			t.Errorf("Expected max_length to fail, but got: %+v", response.Rules)
+		}
+		for _, rule := range response.Rules {
+			if rule.Name != "min_length" && rule.Name != "max_length" && rule.Name != "byte_limit" {
+				t.Errorf("Expected only the length rules, but got: %+v", response.Rules)
+			}
+		}
+	})
+
+	// Test case 5
+	t.Run("no session", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/api/policy/check", strings.NewReader(`{"password": "StrongPassword123!"}`))
+		rr := httptest.NewRecorder()
+		policyCheckAPIHandler(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
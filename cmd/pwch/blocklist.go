@@ -0,0 +1,202 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// shorter words are ignored, otherwise almost every password
+// would contain a blocked word
+const minBlockedWordLength = 3
+
+// caps the number of l33t decodings tried per password, every
+// ambiguous character like "1" (i or l) doubles the variants
+const maxL33tVariants = 64
+
+// words loaded from a blocklist file
+type blocklistFile struct {
+	modTime time.Time
+	words   map[string]struct{}
+	maxLen  int
+}
+
+// blocklist files by path
+//
+// the files are checked for modifications on every lookup
+// and reloaded when changed, so no restart is required
+var passwordBlocklist = struct {
+	sync.Mutex
+	files map[string]*blocklistFile
+}{files: make(map[string]*blocklistFile)}
+
+// returns the first word of the given set contained in the password
+//
+// Matching is case-insensitive and undoes common l33t substitutions,
+// so "P@ssw0rd" contains "password".
+func containsBlockedWord(password string, words map[string]struct{}, maxLen int) (string, bool) {
+	for _, variant := range l33tDecodings(normalizeWord(password)) {
+		runes := []rune(variant)
+		for i := range runes {
+			for j := i + minBlockedWordLength; j <= len(runes) && j-i <= maxLen; j++ {
+				if _, ok := words[string(runes[i:j])]; ok {
+					return string(runes[i:j]), true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// returns the password along with its l33t decodings
+func l33tDecodings(password string) []string {
+	original := []rune(password)
+	decoded := [][]rune{append([]rune(nil), original...)}
+	substituted := false
+
+	for i, char := range original {
+		subs, ok := l33tTable[char]
+		if !ok {
+			continue
+		}
+		substituted = true
+
+		for _, variant := range decoded {
+			for _, sub := range subs[1:] {
+				if len(decoded) >= maxL33tVariants {
+					break
+				}
+				alternative := append([]rune(nil), variant...)
+				alternative[i] = sub
+				decoded = append(decoded, alternative)
+			}
+			variant[i] = subs[0]
+		}
+	}
+
+	if !substituted {
+		return []string{password}
+	}
+	variants := []string{password}
+	for _, variant := range decoded {
+		variants = append(variants, string(variant))
+	}
+	return variants
+}
+
+func normalizeWord(word string) string {
+	return strings.ToLower(norm.NFKC.String(strings.TrimSpace(word)))
+}
+
+// returns words from the users context that must not be part of the
+// password: the username and its parts, the domain labels except the
+// top level domain and the configured service name
+func contextWords(username, domain string) []string {
+	var words []string
+	add := func(word string) {
+		word = normalizeWord(word)
+		if len([]rune(word)) < minBlockedWordLength {
+			return
+		}
+		for _, existing := range words {
+			if existing == word {
+				return
+			}
+		}
+		words = append(words, word)
+	}
+
+	add(username)
+	if parts := strings.FieldsFunc(username, isWordSeparator); len(parts) > 1 {
+		for _, part := range parts {
+			add(part)
+		}
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) > 1 {
+		labels = labels[:len(labels)-1]
+	}
+	for _, label := range labels {
+		add(label)
+	}
+
+	serviceName := domainConfig(domain).PasswordPolicy.Blocklist.ServiceName
+	add(serviceName)
+	for _, part := range strings.FieldsFunc(serviceName, isWordSeparator) {
+		add(part)
+	}
+	return words
+}
+
+func isWordSeparator(char rune) bool {
+	return strings.ContainsRune(" .-_+", char)
+}
+
+// returns the given blocklist files, reloading those
+// that changed since they were last read
+func blockedWords(paths []string) ([]*blocklistFile, error) {
+	passwordBlocklist.Lock()
+	defer passwordBlocklist.Unlock()
+
+	var lists []*blocklistFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		list, ok := passwordBlocklist.files[path]
+		if !ok || !list.modTime.Equal(info.ModTime()) {
+			if list, err = loadBlocklistFile(path); err != nil {
+				return nil, err
+			}
+			list.modTime = info.ModTime()
+			passwordBlocklist.files[path] = list
+		}
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
+
+// reads one word per line, empty lines and lines starting with # are skipped
+func loadBlocklistFile(path string) (*blocklistFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &blocklistFile{words: make(map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := normalizeWord(scanner.Text())
+		if strings.HasPrefix(word, "#") || len([]rune(word)) < minBlockedWordLength {
+			continue
+		}
+		list.words[word] = struct{}{}
+		if len([]rune(word)) > list.maxLen {
+			list.maxLen = len([]rune(word))
+		}
+	}
+	return list, scanner.Err()
+}
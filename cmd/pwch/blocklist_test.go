@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestContextWords(t *testing.T) {
+	cfg.PasswordPolicy.Blocklist.ServiceName = "Example Mail"
+
+	got := contextWords("alice.smith", "mail.example.com")
+	want := []string{"alice.smith", "alice", "smith", "mail", "example", "example mail"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected context words %v, but got: %v", want, got)
+	}
+
+	cfg.PasswordPolicy.Blocklist.ServiceName = ""
+}
+
+func TestContainsBlockedWord(t *testing.T) {
+	words := map[string]struct{}{"alice": {}, "example": {}, "password": {}, "lily": {}}
+
+	testBlocked := func(t testing.TB, password, expectedWord string) {
+		t.Helper()
+
+		word, found := containsBlockedWord(password, words, 8)
+		if expectedWord == "" && found {
+			t.Errorf("Expected no blocked word in '%s', but found: %s", password, word)
+		}
+		if expectedWord != "" && word != expectedWord {
+			t.Errorf("Expected blocked word '%s' in '%s', but got: '%s'", expectedWord, password, word)
+		}
+	}
+
+	// Test case 1
+	t.Run("case insensitive", func(t *testing.T) {
+		testBlocked(t, "Alice@example.com2024", "alice")
+	})
+
+	// Test case 2
+	t.Run("l33t substitution", func(t *testing.T) {
+		testBlocked(t, "P@ssw0rd!", "password")
+	})
+
+	// Test case 3
+	t.Run("ambiguous l33t substitution", func(t *testing.T) {
+		testBlocked(t, "x1i1y", "lily")
+	})
+
+	// Test case 4
+	t.Run("no blocked word", func(t *testing.T) {
+		testBlocked(t, "correct-horse-battery-staple", "")
+	})
+}
+
+func TestBlockedWords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	cfg.PasswordPolicy.Blocklist.Files = []string{path}
+
+	writeBlocklist := func(t testing.TB, content string, modTime time.Time) {
+		t.Helper()
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Test case 1
+	t.Run("load blocklist", func(t *testing.T) {
+		writeBlocklist(t, "# company names\nContoso\n\nab\n", time.Now().Add(-time.Hour))
+
+		lists, err := blockedWords(cfg.PasswordPolicy.Blocklist.Files)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if _, ok := lists[0].words["contoso"]; !ok || len(lists[0].words) != 1 || lists[0].maxLen != 7 {
+			t.Errorf("Unexpected blocklist: %v", lists[0].words)
+		}
+	})
+
+	// Test case 2
+	t.Run("reload changed blocklist", func(t *testing.T) {
+		writeBlocklist(t, "fabrikam\n", time.Now())
+
+		lists, _ := blockedWords(cfg.PasswordPolicy.Blocklist.Files)
+		if _, ok := lists[0].words["fabrikam"]; !ok || len(lists[0].words) != 1 {
+			t.Errorf("Expected reloaded blocklist, but got: %v", lists[0].words)
+		}
+	})
+
+	// Test case 3
+	t.Run("password policy", func(t *testing.T) {
+		cfg.PasswordPolicy.MinLength = 6
+		cfg.PasswordPolicy.MaxLength = 64
+		cfg.PasswordPolicy.LowerCase = false
+		cfg.PasswordPolicy.UpperCase = false
+		cfg.PasswordPolicy.Digits = false
+		cfg.PasswordPolicy.SepcialChar = false
+
+		if valid, _ := enforcePasswordPolicy("F4brik4m-2024", "bob", "example.org"); valid {
+			t.Error("Expected password with blocked word to be invalid")
+		}
+
+		valid, message := enforcePasswordPolicy("Alice@example.com2024", "alice", "example.com")
+		if valid || message != "Please do not use parts of your email address or the service name" {
+			t.Errorf("Unexpected result for password with context words: %s", message)
+		}
+	})
+
+	// Test case 4
+	t.Run("unreadable blocklist fails closed", func(t *testing.T) {
+		cfg.PasswordPolicy.Blocklist.Files = []string{path + ".missing"}
+
+		valid, message := enforcePasswordPolicy("F4brik4m-2024", "bob", "example.org")
+		if valid || message != unverifiableRuleMessage {
+			t.Errorf("Expected error message '%s', but got: %s", unverifiableRuleMessage, message)
+		}
+	})
+
+	cfg.PasswordPolicy.Blocklist.Files = nil
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The breach index is a compact on-disk representation of the
+// Have I Been Pwned SHA-1 password dump. After a short magic header it
+// holds fixed size records sorted by hash, each consisting of the first
+// 8 bytes of the SHA-1 hash and the number of occurrences. Lookups
+// binary search the file without loading it into memory.
+const (
+	breachIndexMagic      = "PWCHIBP1"
+	breachIndexRecordSize = 12
+)
+
+// returns how often the password appears in the breach index
+func breachCount(indexPath, password string) (int, error) {
+	file, err := os.Open(indexPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	records, err := breachIndexRecords(file)
+	if err != nil {
+		return 0, err
+	}
+
+	sum := sha1.Sum([]byte(password))
+	key := binary.BigEndian.Uint64(sum[:8])
+
+	record := make([]byte, breachIndexRecordSize)
+	readRecord := func(i int) (uint64, uint32, error) {
+		_, err := file.ReadAt(record, int64(len(breachIndexMagic)+i*breachIndexRecordSize))
+		if err != nil {
+			return 0, 0, err
+		}
+		return binary.BigEndian.Uint64(record[:8]), binary.BigEndian.Uint32(record[8:]), nil
+	}
+
+	var readErr error
+	i := sort.Search(records, func(i int) bool {
+		prefix, _, err := readRecord(i)
+		if err != nil {
+			readErr = err
+			return true
+		}
+		return prefix >= key
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+	if i == records {
+		return 0, nil
+	}
+
+	prefix, count, err := readRecord(i)
+	if err != nil {
+		return 0, err
+	}
+	if prefix != key {
+		return 0, nil
+	}
+	return int(count), nil
+}
+
+// checks the header and returns the number of records in the index
+func breachIndexRecords(file *os.File) (int, error) {
+	header := make([]byte, len(breachIndexMagic))
+	if _, err := file.ReadAt(header, 0); err != nil || string(header) != breachIndexMagic {
+		return 0, fmt.Errorf("%s is not a breach index", file.Name())
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return int(info.Size()-int64(len(breachIndexMagic))) / breachIndexRecordSize, nil
+}
+
+// builds a breach index from a HIBP dump
+//
+// source is either a single file with "HASH:COUNT" lines or a directory
+// of range files named after the 5 character hash prefix containing
+// "SUFFIX:COUNT" lines, as created by the official HIBP downloader.
+// Both have to be sorted by hash.
+func buildBreachIndex(source, indexPath string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := indexPath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	writer := newBreachIndexWriter(file)
+	if err := writer.writeHeader(); err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			return err
+		}
+		// ReadDir returns entries sorted by file name
+		for _, entry := range entries {
+			prefix := strings.ToUpper(strings.TrimSuffix(entry.Name(), ".txt"))
+			if entry.IsDir() || len(prefix) != 5 {
+				continue
+			}
+			if err := writer.addFile(filepath.Join(source, entry.Name()), prefix); err != nil {
+				return err
+			}
+		}
+	} else if err := writer.addFile(source, ""); err != nil {
+		return err
+	}
+
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, indexPath)
+}
+
+type breachIndexWriter struct {
+	out     *bufio.Writer
+	last    uint64
+	count   uint32
+	pending bool
+}
+
+func newBreachIndexWriter(w io.Writer) *breachIndexWriter {
+	return &breachIndexWriter{out: bufio.NewWriter(w)}
+}
+
+func (w *breachIndexWriter) writeHeader() error {
+	_, err := w.out.WriteString(breachIndexMagic)
+	return err
+}
+
+// reads "HASH:COUNT" lines from path, prefix is prepended to every hash
+func (w *breachIndexWriter) addFile(path, prefix string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		hash, countString, found := strings.Cut(text, ":")
+		if !found {
+			return fmt.Errorf("%s:%d: invalid line", path, line)
+		}
+
+		sum, err := hex.DecodeString(prefix + hash)
+		if err != nil || len(sum) != sha1.Size {
+			return fmt.Errorf("%s:%d: invalid SHA-1 hash", path, line)
+		}
+		count, err := strconv.ParseUint(countString, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid count", path, line)
+		}
+		if count > math.MaxUint32 {
+			count = math.MaxUint32
+		}
+
+		if err := w.add(binary.BigEndian.Uint64(sum[:8]), uint32(count)); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// adds a record, hashes sharing the same truncated prefix are merged
+func (w *breachIndexWriter) add(key uint64, count uint32) error {
+	if w.pending {
+		if key < w.last {
+			return errors.New("source is not sorted by hash")
+		}
+		if key == w.last {
+			if count > w.count {
+				w.count = count
+			}
+			return nil
+		}
+		if err := w.writeRecord(); err != nil {
+			return err
+		}
+	}
+
+	w.last, w.count, w.pending = key, count, true
+	return nil
+}
+
+func (w *breachIndexWriter) writeRecord() error {
+	record := make([]byte, breachIndexRecordSize)
+	binary.BigEndian.PutUint64(record[:8], w.last)
+	binary.BigEndian.PutUint32(record[8:], w.count)
+	_, err := w.out.Write(record)
+	return err
+}
+
+func (w *breachIndexWriter) flush() error {
+	if w.pending {
+		if err := w.writeRecord(); err != nil {
+			return err
+		}
+		w.pending = false
+	}
+	return w.out.Flush()
+}
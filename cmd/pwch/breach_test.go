@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// returns a "HASH:COUNT" line as found in HIBP dumps
+func hibpLine(password string, count int) string {
+	return fmt.Sprintf("%X:%d", sha1.Sum([]byte(password)), count)
+}
+
+// writes a sorted HIBP dump and returns its path
+func writeHIBPDump(t testing.TB, lines ...string) string {
+	t.Helper()
+
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildBreachIndex(t *testing.T) {
+	index := filepath.Join(t.TempDir(), "breach.idx")
+
+	// Test case 1
+	t.Run("test single file dump", func(t *testing.T) {
+		dump := writeHIBPDump(t, hibpLine("Summer2024!!xy", 42), hibpLine("password", 9545824))
+
+		if err := buildBreachIndex(dump, index); err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+
+		count, err := breachCount(index, "Summer2024!!xy")
+		if err != nil || count != 42 {
+			t.Errorf("Expected count 42, but got: %d (%v)", count, err)
+		}
+	})
+
+	// Test case 2
+	t.Run("test range directory dump", func(t *testing.T) {
+		dir := t.TempDir()
+		line := hibpLine("Summer2024!!xy", 7)
+		if err := os.WriteFile(filepath.Join(dir, line[:5]+".txt"), []byte(line[5:]+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := buildBreachIndex(dir, index); err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+
+		count, err := breachCount(index, "Summer2024!!xy")
+		if err != nil || count != 7 {
+			t.Errorf("Expected count 7, but got: %d (%v)", count, err)
+		}
+	})
+
+	// Test case 3
+	t.Run("test unsorted dump", func(t *testing.T) {
+		dump := writeHIBPDump(t)
+		unsorted := "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\n0000000000000000000000000000000000000000:1\n"
+		if err := os.WriteFile(dump, []byte(unsorted), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := buildBreachIndex(dump, index)
+		if err == nil || !strings.Contains(err.Error(), "not sorted") {
+			t.Errorf("Expected sort error, but got: %v", err)
+		}
+	})
+}
+
+func TestBreachCount(t *testing.T) {
+	index := filepath.Join(t.TempDir(), "breach.idx")
+	dump := writeHIBPDump(t,
+		hibpLine("password", 9545824),
+		hibpLine("123456", 37359195),
+		hibpLine("Summer2024!!xy", 3),
+	)
+	if err := buildBreachIndex(dump, index); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case 1
+	t.Run("test breached password", func(t *testing.T) {
+		count, err := breachCount(index, "123456")
+		if err != nil || count != 37359195 {
+			t.Errorf("Expected count 37359195, but got: %d (%v)", count, err)
+		}
+	})
+
+	// Test case 2
+	t.Run("test unknown password", func(t *testing.T) {
+		count, err := breachCount(index, "correct horse battery staple")
+		if err != nil || count != 0 {
+			t.Errorf("Expected count 0, but got: %d (%v)", count, err)
+		}
+	})
+
+	// Test case 3
+	t.Run("test invalid index", func(t *testing.T) {
+		if _, err := breachCount(dump, "123456"); err == nil {
+			t.Error("Expected error, but got nil")
+		}
+	})
+
+	// Test case 4
+	t.Run("test password policy threshold", func(t *testing.T) {
+		cfg.PasswordPolicy.MinLength = 12
+		cfg.PasswordPolicy.MaxLength = 24
+		cfg.PasswordPolicy.Breached.Index = index
+
+		cfg.PasswordPolicy.Breached.Threshold = 5
+		if valid, message := enforcePasswordPolicy("Summer2024!!xy", "", ""); !valid {
+			t.Errorf("Expected password below threshold to be valid, but got: %s", message)
+		}
+
+		cfg.PasswordPolicy.Breached.Threshold = 1
+		valid, message := enforcePasswordPolicy("Summer2024!!xy", "", "")
+		expectedMessage := "This password appeared in a data breach, please choose a different one"
+		if valid || message != expectedMessage {
+			t.Errorf("Expected error message '%s', but got: %s", expectedMessage, message)
+		}
+
+		cfg.PasswordPolicy.Breached.Index = ""
+	})
+
+	// Test case 5
+	t.Run("test unreadable index fails closed", func(t *testing.T) {
+		cfg.PasswordPolicy.MinLength = 12
+		cfg.PasswordPolicy.MaxLength = 24
+		cfg.PasswordPolicy.Breached.Index = index + ".missing"
+
+		valid, message := enforcePasswordPolicy("correct horse battery", "", "")
+		if valid || message != unverifiableRuleMessage {
+			t.Errorf("Expected error message '%s', but got: %s", unverifiableRuleMessage, message)
+		}
+
+		cfg.PasswordPolicy.Breached.Index = ""
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// an ALTCHA style proof of work: the client has to find the number
+// whose SHA-256 hash, appended to the salt, equals the challenge
+type proofOfWork struct {
+	Algorithm string
+	Challenge string
+	Salt      string
+	Signature string
+	MaxNumber int
+}
+
+// signs issued challenges, challenges don't survive a restart
+var challengeKey []byte
+
+// solved challenges by signature and their expiry,
+// a challenge can only be used once
+var usedChallenges = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+func proofOfWorkEnabled() bool {
+	cfg := loadConfig()
+	return cfg.ProofOfWork.MaxNumber > 0
+}
+
+// generates the key challenges are signed with, called once on start
+func initChallengeKey() error {
+	key, err := genRandomBytes(32)
+	if err != nil {
+		return err
+	}
+	challengeKey = key
+	return nil
+}
+
+func challengeSignature(challenge string) string {
+	// a signature without key could be computed by anyone
+	if len(challengeKey) == 0 {
+		panic("challenge key not initialized")
+	}
+
+	mac := hmac.New(sha256.New, challengeKey)
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hashChallenge(salt string, number int) string {
+	sum := sha256.Sum256([]byte(salt + strconv.Itoa(number)))
+	return hex.EncodeToString(sum[:])
+}
+
+// creates a challenge with a random number up to the configured maximum,
+// the expiry is part of the salt and therefore covered by the signature
+func issueChallenge() (proofOfWork, error) {
+	cfg := loadConfig()
+	random, err := genRandomBytes(12)
+	if err != nil {
+		return proofOfWork{}, err
+	}
+	number, err := randomInt(cfg.ProofOfWork.MaxNumber + 1)
+	if err != nil {
+		return proofOfWork{}, err
+	}
+
+	expires := time.Now().Add(cfg.ProofOfWork.ValidFor).Unix()
+	salt := hex.EncodeToString(random) + "?expires=" + strconv.FormatInt(expires, 10)
+	challenge := hashChallenge(salt, number)
+
+	return proofOfWork{
+		Algorithm: "SHA-256",
+		Challenge: challenge,
+		Salt:      salt,
+		Signature: challengeSignature(challenge),
+		MaxNumber: cfg.ProofOfWork.MaxNumber,
+	}, nil
+}
+
+// checks a solved challenge and marks it as used
+func verifyChallenge(salt, challenge, signature, number string) error {
+	cfg := loadConfig()
+	expectedSignature := challengeSignature(challenge)
+	if subtle.ConstantTimeCompare([]byte(expectedSignature), []byte(signature)) != 1 {
+		return errors.New("invalid challenge signature")
+	}
+
+	_, query, _ := strings.Cut(salt, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	unix, err := strconv.ParseInt(params.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("challenge without expiry")
+	}
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return errors.New("challenge expired")
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 || n > cfg.ProofOfWork.MaxNumber || hashChallenge(salt, n) != challenge {
+		return errors.New("challenge not solved")
+	}
+
+	usedChallenges.Lock()
+	defer usedChallenges.Unlock()
+	if _, ok := usedChallenges.m[signature]; ok {
+		return errors.New("challenge already used")
+	}
+	usedChallenges.m[signature] = expires
+	return nil
+}
+
+// forgets used challenges once they expired, they are rejected
+// by their expiry from then on
+func expireChallenges() {
+	usedChallenges.Lock()
+	defer usedChallenges.Unlock()
+
+	now := time.Now()
+	for signature, expires := range usedChallenges.m {
+		if now.After(expires) {
+			delete(usedChallenges.m, signature)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brute forces the challenge like the browser does
+func solveChallenge(t testing.TB, challenge proofOfWork) string {
+	t.Helper()
+
+	for number := 0; number <= challenge.MaxNumber; number++ {
+		if hashChallenge(challenge.Salt, number) == challenge.Challenge {
+			return strconv.Itoa(number)
+		}
+	}
+	t.Fatal("Challenge has no solution")
+	return ""
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	if err := initChallengeKey(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ProofOfWork.MaxNumber = 1000
+	cfg.ProofOfWork.ValidFor = time.Minute
+
+	issue := func(t testing.TB) proofOfWork {
+		t.Helper()
+
+		challenge, err := issueChallenge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return challenge
+	}
+
+	// Test case 1
+	t.Run("solved challenge", func(t *testing.T) {
+		challenge := issue(t)
+		number := solveChallenge(t, challenge)
+
+		if err := verifyChallenge(challenge.Salt, challenge.Challenge, challenge.Signature, number); err != nil {
+			t.Errorf("Expected error to be nil, but got: %v", err)
+		}
+	})
+
+	// Test case 2
+	t.Run("reused challenge", func(t *testing.T) {
+		challenge := issue(t)
+		number := solveChallenge(t, challenge)
+
+		_ = verifyChallenge(challenge.Salt, challenge.Challenge, challenge.Signature, number)
+		if err := verifyChallenge(challenge.Salt, challenge.Challenge, challenge.Signature, number); err == nil {
+			t.Error("Expected reused challenge to be rejected")
+		}
+	})
+
+	// Test case 3
+	t.Run("wrong number", func(t *testing.T) {
+		challenge := issue(t)
+		number, _ := strconv.Atoi(solveChallenge(t, challenge))
+
+		if err := verifyChallenge(challenge.Salt, challenge.Challenge, challenge.Signature, strconv.Itoa(number+1)); err == nil {
+			t.Error("Expected wrong number to be rejected")
+		}
+	})
+
+	// Test case 4
+	t.Run("self made challenge", func(t *testing.T) {
+		salt := "abc?expires=" + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		challenge := hashChallenge(salt, 1)
+
+		if err := verifyChallenge(salt, challenge, "forged", "1"); err == nil {
+			t.Error("Expected challenge with forged signature to be rejected")
+		}
+	})
+
+	// Test case 5
+	t.Run("expired challenge", func(t *testing.T) {
+		salt := "abc?expires=" + strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+		challenge := hashChallenge(salt, 1)
+
+		if err := verifyChallenge(salt, challenge, challengeSignature(challenge), "1"); err == nil {
+			t.Error("Expected expired challenge to be rejected")
+		}
+	})
+
+	// Test case 6
+	t.Run("used challenges are forgotten after expiry", func(t *testing.T) {
+		usedChallenges.m["expired"] = time.Now().Add(-time.Second)
+		expireChallenges()
+
+		if _, ok := usedChallenges.m["expired"]; ok {
+			t.Error("Expected expired challenge to be forgotten")
+		}
+	})
+
+	// Test case 7
+	t.Run("no signature without key", func(t *testing.T) {
+		key := challengeKey
+		challengeKey = nil
+		defer func() {
+			challengeKey = key
+			if recover() == nil {
+				t.Error("Expected signing without key to panic")
+			}
+		}()
+
+		challengeSignature("challenge")
+	})
+
+	cfg.ProofOfWork.MaxNumber = 0
+}
+
+func TestEmailSendHandlerProofOfWork(t *testing.T) {
+	// discard log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	if err := initChallengeKey(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.AssetsPath = "../../assets/html"
+	cfg.ProofOfWork.MaxNumber = 1000
+	cfg.ProofOfWork.ValidFor = time.Minute
+	emailSendLatency = 0
+
+	// Test case 1
+	t.Run("form carries the challenge", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		submitEmailHandler(rr, httptest.NewRequest("GET", "/submitEmail", nil))
+
+		if !strings.Contains(rr.Body.String(), `name="pow-challenge"`) {
+			t.Error("Expected the form to contain the challenge")
+		}
+	})
+
+	submit := func(t testing.TB, form url.Values) int {
+		t.Helper()
+
+		// reset rate limiting
+		lastEmailSent.m = make(map[string]time.Time)
+
+		form.Set("email", "pwch1@localdomain")
+		req := httptest.NewRequest("POST", "/emailSend", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		emailSendHandler(rr, req)
+		return rr.Code
+	}
+
+	// Test case 2
+	t.Run("missing solution", func(t *testing.T) {
+		if code := submit(t, url.Values{}); code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+		}
+	})
+
+	// Test case 3
+	t.Run("solved challenge", func(t *testing.T) {
+		challenge, _ := issueChallenge()
+		form := url.Values{
+			"pow-salt":      {challenge.Salt},
+			"pow-challenge": {challenge.Challenge},
+			"pow-signature": {challenge.Signature},
+			"pow-number":    {solveChallenge(t, challenge)},
+		}
+
+		if code := submit(t, form); code != http.StatusOK {
+			t.Errorf("Expected status code %d, but got %d", http.StatusOK, code)
+		}
+		<-otlRequests
+	})
+
+	cfg.ProofOfWork.MaxNumber = 0
+	emailSendLatency = 500 * time.Millisecond
+
+	// restore log output to stdout
+	log.SetOutput(os.Stdout)
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// creates a fresh CSRF token for the session
+//
+// every render replaces the token, so only the form
+// pwch rendered last can be submitted
+func issueCSRFToken(sessionID string) (string, error) {
+	token, err := genRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	sessions.Lock()
+	defer sessions.Unlock()
+	s, ok := sessions.m[sessionID]
+	if !ok {
+		return "", errors.New("unknown session")
+	}
+	s.CSRFToken = token
+	return token, nil
+}
+
+// checks the submitted token against the one issued for the session
+func validCSRFToken(sessionID, token string) bool {
+	sessions.Lock()
+	var expected string
+	if s, ok := sessions.m[sessionID]; ok {
+		expected = s.CSRFToken
+	}
+	sessions.Unlock()
+
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// rejects requests browsers mark as cross-site, this is a second line
+// of defense in addition to the token
+func sameOriginRequest(r *http.Request) bool {
+	cfg := requestConfig(r)
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	_, scheme := forwardedClient(r)
+	return origin == "" || origin == scheme+"://"+cfg.Domain
+}
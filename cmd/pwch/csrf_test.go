@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCSRFToken(t *testing.T) {
+	otl := newTestSession(t, "pwch1", "localdomain")
+
+	// Test case 1
+	t.Run("issued token is valid", func(t *testing.T) {
+		token, err := issueCSRFToken(otl)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if !validCSRFToken(otl, token) {
+			t.Error("Expected issued token to be valid")
+		}
+	})
+
+	// Test case 2
+	t.Run("new render replaces token", func(t *testing.T) {
+		first, _ := issueCSRFToken(otl)
+		second, _ := issueCSRFToken(otl)
+		if validCSRFToken(otl, first) || !validCSRFToken(otl, second) {
+			t.Error("Expected only the latest token to be valid")
+		}
+	})
+
+	// Test case 3
+	t.Run("token bound to other session", func(t *testing.T) {
+		token, _ := issueCSRFToken(newTestSession(t, "pwch2", "localdomain"))
+		if validCSRFToken(otl, token) {
+			t.Error("Expected token of another session to be invalid")
+		}
+	})
+
+	// Test case 4
+	t.Run("unknown session", func(t *testing.T) {
+		if _, err := issueCSRFToken("unknown"); err == nil {
+			t.Error("Expected error for unknown session")
+		}
+		if validCSRFToken("unknown", "") {
+			t.Error("Expected empty token of unknown session to be invalid")
+		}
+	})
+
+	// Test case 5
+	t.Run("ended session", func(t *testing.T) {
+		token, _ := issueCSRFToken(otl)
+		deleteSession(otl)
+		if validCSRFToken(otl, token) {
+			t.Error("Expected token of ended session to be invalid")
+		}
+	})
+}
+
+func TestPasswordSubmitHandlerCSRF(t *testing.T) {
+	// discard log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	cfg.Domain = "localhost"
+	cfg.AssetsPath = "../../assets/html"
+
+	otl := newTestSession(t, "pwch1", "localdomain")
+
+	submit := func(t testing.TB, csrfToken string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+
+		form := url.Values{}
+		form.Add("csrf-token", csrfToken)
+		form.Add("current-password", "password")
+		form.Add("new-password", "StrongPassword123!")
+		form.Add("confirm-password", "StrongPassword123!")
+
+		req, err := http.NewRequest("POST", "/submitPassword", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: otl})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		rr := httptest.NewRecorder()
+		passwordSubmitHandler(rr, req)
+		return rr
+	}
+
+	// Test case 1
+	t.Run("missing token", func(t *testing.T) {
+		rr := submit(t, "", nil)
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "reload the page") {
+			t.Errorf("Expected rendered 403 error page, got %d", rr.Code)
+		}
+	})
+
+	// Test case 2
+	t.Run("token of an older render", func(t *testing.T) {
+		old, _ := issueCSRFToken(otl)
+		issueCSRFToken(otl)
+		if rr := submit(t, old, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	// Test case 3
+	t.Run("cross-site request", func(t *testing.T) {
+		token, _ := issueCSRFToken(otl)
+		header := http.Header{"Origin": {"https://evil.example"}}
+		if rr := submit(t, token, header); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+
+		header = http.Header{"Sec-Fetch-Site": {"cross-site"}}
+		if rr := submit(t, token, header); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	deleteSession(otl)
+
+	// restore log output to stdout
+	log.SetOutput(os.Stdout)
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultBrandingTitle = "Password Reset"
+
+// settings that can be overridden per mail domain in the domains section
+type domainSettings struct {
+	PasswordPolicy passwordPolicy `yaml:"password_policy"`
+	OTL            otlSettings    `yaml:"otl"`
+	Sender         string         `yaml:"sender"`
+	Branding       branding       `yaml:"branding"`
+}
+
+// returns the global settings every domain starts from
+func defaultDomainSettings(cfg *config) domainSettings {
+	settings := domainSettings{
+		PasswordPolicy: cfg.PasswordPolicy,
+		OTL:            cfg.OTL,
+		Sender:         cfg.SMTP.Sender,
+		Branding:       cfg.Branding,
+	}
+	if settings.Branding.Title == "" {
+		settings.Branding.Title = defaultBrandingTitle
+	}
+	return settings
+}
+
+// decodes every entry of the domains section on top of a copy of the
+// global settings, so a domain only has to list what it changes
+func resolveDomainSettings(cfg *config) error {
+	cfg.domainSettings = make(map[string]domainSettings, len(cfg.Domains))
+	for domain, node := range cfg.Domains {
+		settings := defaultDomainSettings(cfg)
+		// slices are shared with the global settings otherwise
+		settings.PasswordPolicy.Blocklist.Files = append([]string(nil), settings.PasswordPolicy.Blocklist.Files...)
+
+		if err := node.Decode(&settings); err != nil {
+			return fmt.Errorf("domains.%s: %w", domain, err)
+		}
+		cfg.domainSettings[strings.ToLower(domain)] = settings
+	}
+	return nil
+}
+
+// returns the settings for the given mail domain
+func domainConfig(domain string) domainSettings {
+	cfg := loadConfig()
+	if settings, ok := cfg.domainSettings[strings.ToLower(domain)]; ok {
+		return settings
+	}
+	return defaultDomainSettings(cfg)
+}
+
+// formats a link lifetime for the email text, e.g. "10 minutes"
+func formatValidity(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return pluralize(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour && d%time.Hour == 0:
+		return pluralize(int(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return pluralize(int(d/time.Minute), "minute")
+	default:
+		return d.String()
+	}
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
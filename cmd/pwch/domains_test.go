@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestResolveDomainSettings(t *testing.T) {
+	input := `
+smtp:
+  sender: noreply@example.org
+password_policy:
+  min_length: 12
+  digits: true
+otl:
+  valid_for: 10m
+domains:
+  Customer.example:
+    password_policy:
+      min_length: 20
+    otl:
+      valid_for: 1h
+    sender: support@customer.example
+    branding:
+      title: Customer Mail
+`
+
+	var testCfg config
+	if err := yaml.Unmarshal([]byte(input), &testCfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolveDomainSettings(&testCfg); err != nil {
+		t.Fatalf("Expected error to be nil, but got: %v", err)
+	}
+
+	settings, ok := testCfg.domainSettings["customer.example"]
+	if !ok {
+		t.Fatal("Expected settings for customer.example")
+	}
+
+	// Test case 1
+	t.Run("overridden settings", func(t *testing.T) {
+		if settings.PasswordPolicy.MinLength != 20 || settings.OTL.ValidFor != time.Hour ||
+			settings.Sender != "support@customer.example" || settings.Branding.Title != "Customer Mail" {
+			t.Errorf("Unexpected domain settings: %+v", settings)
+		}
+	})
+
+	// Test case 2
+	t.Run("inherited settings", func(t *testing.T) {
+		if !settings.PasswordPolicy.Digits {
+			t.Error("Expected digits rule to be inherited from the global password policy")
+		}
+	})
+
+	// Test case 3
+	t.Run("global settings untouched", func(t *testing.T) {
+		if testCfg.PasswordPolicy.MinLength != 12 || testCfg.OTL.ValidFor != 10*time.Minute {
+			t.Errorf("Expected global settings to be unchanged, but got: %+v", testCfg.PasswordPolicy)
+		}
+	})
+}
+
+func TestDomainConfig(t *testing.T) {
+	cfg.PasswordPolicy.MinLength = 6
+	cfg.SMTP.Sender = "noreply@localdomain"
+	cfg.Branding.Title = ""
+
+	override := defaultDomainSettings(&cfg)
+	override.PasswordPolicy.MinLength = 20
+	cfg.domainSettings = map[string]domainSettings{"customer.example": override}
+
+	// Test case 1
+	t.Run("domain with overrides", func(t *testing.T) {
+		if got := domainConfig("Customer.Example").PasswordPolicy.MinLength; got != 20 {
+			t.Errorf("Expected min length 20, but got: %d", got)
+		}
+	})
+
+	// Test case 2
+	t.Run("domain without overrides", func(t *testing.T) {
+		settings := domainConfig("localdomain")
+		if settings.PasswordPolicy.MinLength != 6 || settings.Sender != "noreply@localdomain" {
+			t.Errorf("Expected global settings, but got: %+v", settings)
+		}
+		if settings.Branding.Title != defaultBrandingTitle {
+			t.Errorf("Expected default title, but got: %s", settings.Branding.Title)
+		}
+	})
+
+	// Test case 3
+	t.Run("change page shows the domain's rules", func(t *testing.T) {
+		cfg.AssetsPath = "../../assets/html"
+		sessionID := newTestSession(t, "alice", "customer.example")
+
+		req, err := http.NewRequest("GET", "/changePassword", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionID})
+		rr := httptest.NewRecorder()
+		passwordChangeHandler(rr, req)
+
+		if !strings.Contains(rr.Body.String(), "Must be at least 20 characters long") {
+			t.Error("Expected the change page to show the domain's minimum length")
+		}
+		deleteSession(sessionID)
+	})
+
+	cfg.domainSettings = nil
+}
+
+func TestFormatValidity(t *testing.T) {
+	tests := map[time.Duration]string{
+		10 * time.Minute: "10 minutes",
+		time.Minute:      "1 minute",
+		2 * time.Hour:    "2 hours",
+		72 * time.Hour:   "3 days",
+		90 * time.Second: "1m30s",
+	}
+
+	for duration, want := range tests {
+		if got := formatValidity(duration); got != want {
+			t.Errorf("Expected %s, but got: %s", want, got)
+		}
+	}
+}
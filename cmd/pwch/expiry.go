@@ -0,0 +1,174 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// how often the scheduler looks for accounts to remind, forced
+// password changes should be mailed soon after they were flagged
+const reminderCheckInterval = time.Minute
+
+// password expiry is disabled unless a maximum age is configured
+// globally or for at least one domain
+func passwordExpiryEnabled() bool {
+	cfg := loadConfig()
+	if cfg.PasswordPolicy.Expiry.MaxAge > 0 {
+		return true
+	}
+	for _, settings := range cfg.domainSettings {
+		if settings.PasswordPolicy.Expiry.MaxAge > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// account whose password age is tracked
+type passwordAge struct {
+	Username     string
+	Domain       string
+	ChangedAt    time.Time
+	ReminderSent *time.Time
+	MustChange   bool
+}
+
+// decides whether a reminder has to be sent for the account
+func reminderDue(account passwordAge, now time.Time) bool {
+	expiry := domainConfig(account.Domain).PasswordPolicy.Expiry
+	if account.MustChange {
+		return account.ReminderSent == nil ||
+			expiry.RemindEvery > 0 && now.Sub(*account.ReminderSent) >= expiry.RemindEvery
+	}
+	if expiry.MaxAge <= 0 {
+		return false
+	}
+
+	expiresAt := account.ChangedAt.Add(expiry.MaxAge)
+	if now.Before(expiresAt.Add(-expiry.RemindBefore)) {
+		return false
+	}
+
+	if account.ReminderSent == nil {
+		return true
+	}
+	return expiry.RemindEvery > 0 && now.Sub(*account.ReminderSent) >= expiry.RemindEvery
+}
+
+// formats the time left until the password expires, e.g. "13 days"
+func formatRemaining(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return pluralize(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour:
+		return pluralize(int(d/time.Hour), "hour")
+	default:
+		return pluralize(int(d/time.Minute), "minute")
+	}
+}
+
+// returns when the password of the account expires, ok is false
+// if expiry is disabled for the domain or the lookup failed
+func passwordExpiresAt(username, domain string) (expiresAt time.Time, ok bool) {
+	maxAge := domainConfig(domain).PasswordPolicy.Expiry.MaxAge
+	if maxAge <= 0 {
+		return time.Time{}, false
+	}
+
+	db := connectToDatabase()
+	defer closeDatabase(db)
+
+	var changedAt time.Time
+	if err := db.QueryRow("SELECT password_changed_at FROM accounts WHERE username = $1 AND domain = $2;",
+		username, domain).Scan(&changedAt); err != nil {
+		log.Print(err)
+		log.Print("ERROR: cannot fetch password age of " + username + "@" + domain)
+		return time.Time{}, false
+	}
+	return changedAt.Add(maxAge), true
+}
+
+// mails a fresh one time link to every user who has been forced to
+// change the password or whose password is nearing its maximum age
+func sendPasswordReminders() {
+	db := connectToDatabase()
+	defer closeDatabase(db)
+
+	rows, err := db.Query(`SELECT username, domain, password_changed_at, password_reminder_sent_at, must_change_password
+		FROM accounts WHERE enabled = true AND sendonly = false AND (must_change_password = true OR $1);`,
+		passwordExpiryEnabled())
+	if err != nil {
+		log.Print(err)
+		log.Print("ERROR: password age query failed")
+		return
+	}
+
+	var due []passwordAge
+	now := time.Now()
+	for rows.Next() {
+		var account passwordAge
+		if err := rows.Scan(&account.Username, &account.Domain, &account.ChangedAt, &account.ReminderSent, &account.MustChange); err != nil {
+			log.Print(err)
+			continue
+		}
+		if reminderDue(account, now) {
+			due = append(due, account)
+		}
+	}
+	rows.Close()
+
+	for _, account := range due {
+		if err := sendPasswordReminder(account, now); err != nil {
+			continue
+		}
+
+		if _, err := db.Exec("UPDATE accounts SET password_reminder_sent_at = $1 WHERE username = $2 AND domain = $3;",
+			now, account.Username, account.Domain); err != nil {
+			log.Print(err)
+			log.Print("ERROR: cannot record reminder for " + account.Username + "@" + account.Domain)
+		}
+	}
+}
+
+func sendPasswordReminder(account passwordAge, now time.Time) error {
+	expiry := domainConfig(account.Domain).PasswordPolicy.Expiry
+	expiresAt := account.ChangedAt.Add(expiry.MaxAge)
+
+	var subject, intro string
+	switch {
+	case account.MustChange:
+		subject = "Password change required"
+		intro = "An administrator requires you to change your password, " +
+			"logging in to your mailbox is disabled until you do. Follow this link to change it:"
+	case !now.Before(expiresAt):
+		subject = "Your password has expired"
+		intro = "Your password has expired. Follow this link to change it:"
+	default:
+		subject = "Your password expires soon"
+		intro = fmt.Sprintf("Your password expires in %s. Follow this link to change it:", formatRemaining(expiresAt.Sub(now)))
+	}
+
+	validFor := expiry.LinkValidFor
+	if validFor <= 0 {
+		validFor = domainConfig(account.Domain).OTL.ValidFor
+	}
+
+	return mailOneTimeLink(account.Username, account.Domain, subject, intro,
+		"You can also request a new link on the password reset page at any time.", validFor, defaultBaseURL())
+}
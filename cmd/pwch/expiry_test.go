@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReminderDue(t *testing.T) {
+	cfg.PasswordPolicy.Expiry.MaxAge = 90 * 24 * time.Hour
+	cfg.PasswordPolicy.Expiry.RemindBefore = 14 * 24 * time.Hour
+	cfg.PasswordPolicy.Expiry.RemindEvery = 72 * time.Hour
+
+	now := time.Now()
+	daysAgo := func(days int) time.Time {
+		return now.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+
+	testDue := func(t testing.TB, account passwordAge, expected bool) {
+		t.Helper()
+
+		if got := reminderDue(account, now); got != expected {
+			t.Errorf("Expected reminder due to be %t, but got: %t", expected, got)
+		}
+	}
+
+	// Test case 1
+	t.Run("recently changed password", func(t *testing.T) {
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: daysAgo(30)}, false)
+	})
+
+	// Test case 2
+	t.Run("password nearing maximum age", func(t *testing.T) {
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: daysAgo(80)}, true)
+	})
+
+	// Test case 3
+	t.Run("reminder sent recently", func(t *testing.T) {
+		sent := daysAgo(1)
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: daysAgo(80), ReminderSent: &sent}, false)
+	})
+
+	// Test case 4
+	t.Run("repeat reminder for expired password", func(t *testing.T) {
+		sent := daysAgo(4)
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: daysAgo(100), ReminderSent: &sent}, true)
+	})
+
+	// Test case 5
+	t.Run("single reminder", func(t *testing.T) {
+		cfg.PasswordPolicy.Expiry.RemindEvery = 0
+		sent := daysAgo(10)
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: daysAgo(100), ReminderSent: &sent}, false)
+	})
+
+	// Test case 6
+	t.Run("expiry disabled", func(t *testing.T) {
+		cfg.PasswordPolicy.Expiry.MaxAge = 0
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: daysAgo(1000)}, false)
+		if passwordExpiryEnabled() {
+			t.Error("Expected password expiry to be disabled")
+		}
+	})
+
+	// Test case 7
+	t.Run("forced password change", func(t *testing.T) {
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: now, MustChange: true}, true)
+
+		sent := daysAgo(1)
+		testDue(t, passwordAge{Domain: "localdomain", ChangedAt: now, MustChange: true, ReminderSent: &sent}, false)
+	})
+
+	cfg.PasswordPolicy.Expiry.RemindBefore = 0
+}
+
+func TestFormatRemaining(t *testing.T) {
+	tests := map[time.Duration]string{
+		13*24*time.Hour + 5*time.Hour: "13 days",
+		25 * time.Hour:                "1 day",
+		5*time.Hour + 59*time.Minute:  "5 hours",
+		42 * time.Minute:              "42 minutes",
+	}
+
+	for remaining, want := range tests {
+		if got := formatRemaining(remaining); got != want {
+			t.Errorf("Expected %s, but got: %s", want, got)
+		}
+	}
+}
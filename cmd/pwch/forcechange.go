@@ -0,0 +1,70 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Dovecot password_query denying logins of flagged accounts
+const dovecotPasswordQuery = "password_query = SELECT username AS user, domain, password, " +
+	"encode(digest('%w', 'sha3-512'), 'hex') AS userdb_mail_crypt_private_password " +
+	"FROM accounts WHERE username = '%Ln' AND domain = '%Ld' and enabled = true " +
+	"AND must_change_password = false;"
+
+func printDovecotQuery() {
+	fmt.Println(dovecotPasswordQuery)
+}
+
+// returns the WHERE clause and arguments selecting the accounts of
+// target, which is either "all", a domain or a user@domain address
+func forceChangeCondition(target string) (string, []any, error) {
+	switch {
+	case target == "all":
+		return "true", nil, nil
+	case strings.Contains(target, "@"):
+		if !isValidEmail(target) {
+			return "", nil, fmt.Errorf("invalid email address %s", target)
+		}
+		username, domain, _ := strings.Cut(target, "@")
+		return "username = $1 AND domain = $2", []any{username, domain}, nil
+	case target != "" && !strings.HasPrefix(target, "-"):
+		return "domain = $1", []any{target}, nil
+	default:
+		return "", nil, errors.New("expected all, a domain or user@domain")
+	}
+}
+
+// flags the accounts of target to change their password, the
+// running pwch instance mails them a link on its next check
+func forcePasswordChange(target string) (int64, error) {
+	condition, args, err := forceChangeCondition(target)
+	if err != nil {
+		return 0, err
+	}
+
+	db := connectToDatabase()
+	defer closeDatabase(db)
+
+	result, err := db.Exec("UPDATE accounts SET must_change_password = true, password_reminder_sent_at = NULL WHERE "+
+		condition+";", args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForceChangeCondition(t *testing.T) {
+	testCondition := func(t testing.TB, target, expectedCondition string, expectedArgs []any) {
+		t.Helper()
+
+		condition, args, err := forceChangeCondition(target)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if condition != expectedCondition || !reflect.DeepEqual(args, expectedArgs) {
+			t.Errorf("Expected %s %v, but got: %s %v", expectedCondition, expectedArgs, condition, args)
+		}
+	}
+
+	// Test case 1
+	t.Run("single user", func(t *testing.T) {
+		testCondition(t, "pwch1@localdomain", "username = $1 AND domain = $2", []any{"pwch1", "localdomain"})
+	})
+
+	// Test case 2
+	t.Run("domain", func(t *testing.T) {
+		testCondition(t, "localdomain", "domain = $1", []any{"localdomain"})
+	})
+
+	// Test case 3
+	t.Run("everyone", func(t *testing.T) {
+		testCondition(t, "all", "true", nil)
+	})
+
+	// Test case 4
+	t.Run("invalid target", func(t *testing.T) {
+		for _, target := range []string{"", "--config", "not an@address"} {
+			if _, _, err := forceChangeCondition(target); err == nil {
+				t.Errorf("Expected error for target '%s', but got nil", target)
+			}
+		}
+	})
+}
+
+func TestPrintDovecotQuery(t *testing.T) {
+	// Create a pipe to capture standard output
+	readPipe, writePipe, _ := os.Pipe()
+	defer readPipe.Close()
+
+	// Redirect standard output to the write end of the pipe
+	oldStdout := os.Stdout
+	os.Stdout = writePipe
+
+	printDovecotQuery()
+
+	// Restore standard output
+	os.Stdout = oldStdout
+	writePipe.Close()
+
+	outputBytes, _ := io.ReadAll(readPipe)
+	output := strings.TrimSpace(string(outputBytes))
+
+	// the example config has to stay in sync with the generated query
+	example, err := os.ReadFile("../../config/dovecot-sql.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(example), output+"\n") {
+		t.Errorf("Expected config/dovecot-sql.conf to contain:\n%s", output)
+	}
+	if !strings.HasSuffix(output, "AND must_change_password = false;") {
+		t.Errorf("Unexpected password_query: %s", output)
+	}
+}
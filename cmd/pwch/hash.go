@@ -0,0 +1,569 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scheme of hashes stored without a {SCHEME} prefix.
+// Has to match default_pass_scheme in dovecot-sql.conf
+const defaultPassScheme = "BLF-CRYPT"
+
+const (
+	defaultSHA512CryptRounds = 5000
+	minSHA512CryptRounds     = 1000
+	maxSHA512CryptRounds     = 999999999
+	defaultArgon2IDTime      = 3
+	defaultArgon2IDMemory    = 65536
+	defaultPBKDF2Rounds      = 5000
+)
+
+// alphabet used by crypt(3) for salts and hash encoding
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+type passwordScheme struct {
+	generate func(password string) (string, error)
+	verify   func(password, hash string) bool
+}
+
+// password schemes pwch is able to write and read,
+// keyed by their dovecot name
+var passwordSchemes = map[string]passwordScheme{
+	"BLF-CRYPT":    {generate: generateBcrypt, verify: verifyBcrypt},
+	"SHA512-CRYPT": {generate: generateSHA512Crypt, verify: verifySHA512Crypt},
+	"ARGON2ID":     {generate: generateArgon2ID, verify: verifyArgon2ID},
+	"PBKDF2":       {generate: generatePBKDF2, verify: verifyPBKDF2},
+}
+
+// returns the scheme new hashes are generated with
+func configuredScheme() string {
+	cfg := loadConfig()
+	if cfg.Hash.Scheme == "" {
+		return defaultPassScheme
+	}
+	return strings.ToUpper(cfg.Hash.Scheme)
+}
+
+// splits a dovecot style "{SCHEME}hash" into scheme and hash.
+// Hashes without prefix are assumed to use defaultPassScheme.
+func splitSchemePrefix(hash string) (string, string) {
+	if strings.HasPrefix(hash, "{") {
+		if end := strings.Index(hash, "}"); end > 0 {
+			return strings.ToUpper(hash[1:end]), hash[end+1:]
+		}
+	}
+	return defaultPassScheme, hash
+}
+
+func hashPassword(password string) (string, error) {
+	name := configuredScheme()
+	scheme, ok := passwordSchemes[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported password scheme %s", name)
+	}
+
+	hash, err := scheme.generate(password)
+	if err != nil {
+		return "", err
+	}
+	return "{" + name + "}" + hash, nil
+}
+
+func checkPasswordHash(password, hash string) bool {
+	name, encoded := splitSchemePrefix(hash)
+	scheme, ok := passwordSchemes[name]
+	if !ok {
+		log.Printf("ERROR: unsupported password scheme %s", name)
+		return false
+	}
+	return scheme.verify(password, encoded)
+}
+
+// describes scheme and work factor of a hash, e.g. "BLF-CRYPT cost=10"
+func hashParameters(hash string) string {
+	name, encoded := splitSchemePrefix(hash)
+
+	switch name {
+	case "BLF-CRYPT":
+		if cost, err := bcrypt.Cost([]byte(encoded)); err == nil {
+			return fmt.Sprintf("%s cost=%d", name, cost)
+		}
+	case "SHA512-CRYPT":
+		if rounds, _, err := parseSHA512Crypt(encoded); err == nil {
+			return fmt.Sprintf("%s rounds=%d", name, rounds)
+		}
+	case "ARGON2ID":
+		if time, memory, _, _, _, err := parseArgon2ID(encoded); err == nil {
+			return fmt.Sprintf("%s m=%d,t=%d", name, memory, time)
+		}
+	case "PBKDF2":
+		if rounds, _, _, err := parsePBKDF2(encoded); err == nil {
+			return fmt.Sprintf("%s rounds=%d", name, rounds)
+		}
+	}
+	return name
+}
+
+// describes scheme and work factor new hashes are generated with
+func configuredHashParameters() string {
+	cfg := loadConfig()
+	name := configuredScheme()
+
+	switch name {
+	case "BLF-CRYPT":
+		return fmt.Sprintf("%s cost=%d", name, cfg.Bcrypt.Cost)
+	case "SHA512-CRYPT":
+		return fmt.Sprintf("%s rounds=%d", name, sha512CryptRounds())
+	case "ARGON2ID":
+		return fmt.Sprintf("%s m=%d,t=%d", name, argon2IDMemory(), argon2IDTime())
+	case "PBKDF2":
+		return fmt.Sprintf("%s rounds=%d", name, pbkdf2Rounds())
+	}
+	return name
+}
+
+// reports whether a hash uses another scheme or a lower work factor
+// than currently configured. Such hashes get replaced on the next
+// successful password change.
+func hashOutdated(hash string) bool {
+	cfg := loadConfig()
+	name, encoded := splitSchemePrefix(hash)
+	if name != configuredScheme() {
+		return true
+	}
+
+	switch name {
+	case "BLF-CRYPT":
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost < cfg.Bcrypt.Cost
+	case "SHA512-CRYPT":
+		rounds, _, err := parseSHA512Crypt(encoded)
+		return err != nil || rounds < sha512CryptRounds()
+	case "ARGON2ID":
+		time, memory, _, _, _, err := parseArgon2ID(encoded)
+		return err != nil || time < argon2IDTime() || memory < argon2IDMemory()
+	case "PBKDF2":
+		rounds, _, _, err := parsePBKDF2(encoded)
+		return err != nil || rounds < pbkdf2Rounds()
+	}
+	return false
+}
+
+// prints how many of the given hashes use outdated parameters
+func printHashReport(hashes []string) {
+	outdated := make(map[string]int)
+	total := 0
+	for _, hash := range hashes {
+		if hashOutdated(hash) {
+			outdated[hashParameters(hash)]++
+			total++
+		}
+	}
+
+	parameters := make([]string, 0, len(outdated))
+	for p := range outdated {
+		parameters = append(parameters, p)
+	}
+	sort.Strings(parameters)
+
+	fmt.Println("Configured:")
+	fmt.Printf("  %s\n", configuredHashParameters())
+
+	fmt.Println("Accounts:")
+	fmt.Printf("  %d total, %d outdated\n", len(hashes), total)
+
+	if total > 0 {
+		fmt.Println("Outdated:")
+		for _, p := range parameters {
+			fmt.Printf("  %s \t %d\n", p, outdated[p])
+		}
+	}
+}
+
+// reads all password hashes from the database
+func fetchPasswordHashes() ([]string, error) {
+	db := connectToDatabase()
+	defer closeDatabase(db)
+
+	rows, err := db.Query("SELECT password FROM accounts;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+func genCryptSalt(n int) (string, error) {
+	b, err := genRandomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = cryptAlphabet[int(b[i])%len(cryptAlphabet)]
+	}
+	return string(b), nil
+}
+
+//
+// BLF-CRYPT
+//
+
+func generateBcrypt(password string) (string, error) {
+	cfg := loadConfig()
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cfg.Bcrypt.Cost)
+	return string(bytes), err
+}
+
+// a mismatch is not logged, it's the expected outcome when
+// checking a password against the password history
+func verifyBcrypt(password, hash string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			log.Print(err)
+		}
+		return false
+	}
+	return true
+}
+
+//
+// SHA512-CRYPT
+//
+
+func sha512CryptRounds() int {
+	cfg := loadConfig()
+	if cfg.Hash.SHA512Crypt.Rounds == 0 {
+		return defaultSHA512CryptRounds
+	}
+	return cfg.Hash.SHA512Crypt.Rounds
+}
+
+func generateSHA512Crypt(password string) (string, error) {
+	salt, err := genCryptSalt(16)
+	if err != nil {
+		return "", err
+	}
+	return sha512Crypt(password, salt, sha512CryptRounds()), nil
+}
+
+func verifySHA512Crypt(password, hash string) bool {
+	rounds, salt, err := parseSHA512Crypt(hash)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	// compare the encoded digests only, as the rounds parameter is
+	// optional in the stored hash if it equals the default
+	computed := sha512Crypt(password, salt, rounds)
+	computed = computed[strings.LastIndex(computed, "$")+1:]
+	stored := hash[strings.LastIndex(hash, "$")+1:]
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(stored)) == 1
+}
+
+// parses "$6$[rounds=N$]salt$hash" and returns rounds and salt
+func parseSHA512Crypt(hash string) (int, string, error) {
+	if !strings.HasPrefix(hash, "$6$") {
+		return 0, "", errors.New("invalid SHA512-CRYPT hash")
+	}
+	parts := strings.Split(hash[3:], "$")
+
+	rounds := defaultSHA512CryptRounds
+	if strings.HasPrefix(parts[0], "rounds=") {
+		var err error
+		rounds, err = strconv.Atoi(strings.TrimPrefix(parts[0], "rounds="))
+		if err != nil {
+			return 0, "", errors.New("invalid SHA512-CRYPT rounds")
+		}
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return 0, "", errors.New("invalid SHA512-CRYPT hash")
+	}
+	return rounds, parts[0], nil
+}
+
+// implements the SHA-512 based crypt(3) algorithm as specified by
+// https://www.akkadia.org/drepper/SHA-crypt.txt
+func sha512Crypt(password, salt string, rounds int) string {
+	explicitRounds := rounds != defaultSHA512CryptRounds
+	if rounds < minSHA512CryptRounds {
+		rounds = minSHA512CryptRounds
+	} else if rounds > maxSHA512CryptRounds {
+		rounds = maxSHA512CryptRounds
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	pw := []byte(password)
+	s := []byte(salt)
+
+	digestB := sha512.New()
+	digestB.Write(pw)
+	digestB.Write(s)
+	digestB.Write(pw)
+	b := digestB.Sum(nil)
+
+	digestA := sha512.New()
+	digestA.Write(pw)
+	digestA.Write(s)
+	for i := len(pw); i > 0; i -= 64 {
+		if i > 64 {
+			digestA.Write(b)
+		} else {
+			digestA.Write(b[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			digestA.Write(b)
+		} else {
+			digestA.Write(pw)
+		}
+	}
+	a := digestA.Sum(nil)
+
+	digestP := sha512.New()
+	for range pw {
+		digestP.Write(pw)
+	}
+	dp := digestP.Sum(nil)
+	p := make([]byte, len(pw))
+	for i := 0; i < len(p); i += 64 {
+		copy(p[i:], dp)
+	}
+
+	digestS := sha512.New()
+	for i := 0; i < 16+int(a[0]); i++ {
+		digestS.Write(s)
+	}
+	ds := digestS.Sum(nil)
+	sb := make([]byte, len(s))
+	copy(sb, ds)
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		digestC := sha512.New()
+		if i&1 != 0 {
+			digestC.Write(p)
+		} else {
+			digestC.Write(c)
+		}
+		if i%3 != 0 {
+			digestC.Write(sb)
+		}
+		if i%7 != 0 {
+			digestC.Write(p)
+		}
+		if i&1 != 0 {
+			digestC.Write(c)
+		} else {
+			digestC.Write(p)
+		}
+		c = digestC.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString("$6$")
+	if explicitRounds {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt + "$")
+
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for i := 0; i < 21; i++ {
+		switch i % 3 {
+		case 0:
+			encode(c[i], c[i+21], c[i+42], 4)
+		case 1:
+			encode(c[i+21], c[i+42], c[i], 4)
+		case 2:
+			encode(c[i+42], c[i], c[i+21], 4)
+		}
+	}
+	encode(0, 0, c[63], 2)
+
+	return out.String()
+}
+
+//
+// ARGON2ID
+//
+
+func argon2IDTime() uint32 {
+	cfg := loadConfig()
+	if cfg.Hash.Argon2ID.Time == 0 {
+		return defaultArgon2IDTime
+	}
+	return cfg.Hash.Argon2ID.Time
+}
+
+func argon2IDMemory() uint32 {
+	cfg := loadConfig()
+	if cfg.Hash.Argon2ID.Memory == 0 {
+		return defaultArgon2IDMemory
+	}
+	return cfg.Hash.Argon2ID.Memory
+}
+
+// dovecot verifies ARGON2ID through libsodium which only supports
+// a parallelism of 1, so the thread count is not configurable
+func generateArgon2ID(password string) (string, error) {
+	salt, err := genRandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	time := argon2IDTime()
+	memory := argon2IDMemory()
+
+	key := argon2.IDKey([]byte(password), salt, time, memory, 1, 32)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=1$%s$%s", argon2.Version, memory, time,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func verifyArgon2ID(password, hash string) bool {
+	time, memory, threads, salt, key, err := parseArgon2ID(hash)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+// parses "$argon2id$v=19$m=M,t=T,p=P$salt$key"
+func parseArgon2ID(hash string) (uint32, uint32, uint8, []byte, []byte, error) {
+	invalid := errors.New("invalid ARGON2ID hash")
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return 0, 0, 0, nil, nil, invalid
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return 0, 0, 0, nil, nil, invalid
+	}
+
+	var time, memory uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return 0, 0, 0, nil, nil, invalid
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return 0, 0, 0, nil, nil, invalid
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return 0, 0, 0, nil, nil, invalid
+	}
+
+	return time, memory, threads, salt, key, nil
+}
+
+//
+// PBKDF2
+//
+
+func pbkdf2Rounds() int {
+	cfg := loadConfig()
+	if cfg.Hash.PBKDF2.Rounds == 0 {
+		return defaultPBKDF2Rounds
+	}
+	return cfg.Hash.PBKDF2.Rounds
+}
+
+// dovecot's PBKDF2 scheme uses HMAC-SHA1 with a 20 byte key
+func generatePBKDF2(password string) (string, error) {
+	salt, err := genCryptSalt(16)
+	if err != nil {
+		return "", err
+	}
+
+	rounds := pbkdf2Rounds()
+
+	key := pbkdf2.Key([]byte(password), []byte(salt), rounds, sha1.Size, sha1.New)
+	return fmt.Sprintf("$1$%s$%d$%s", salt, rounds, hex.EncodeToString(key)), nil
+}
+
+func verifyPBKDF2(password, hash string) bool {
+	rounds, salt, key, err := parsePBKDF2(hash)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	computed := pbkdf2.Key([]byte(password), []byte(salt), rounds, len(key), sha1.New)
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+// parses "$1$salt$rounds$hexkey"
+func parsePBKDF2(hash string) (int, string, []byte, error) {
+	invalid := errors.New("invalid PBKDF2 hash")
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != "1" {
+		return 0, "", nil, invalid
+	}
+
+	rounds, err := strconv.Atoi(parts[3])
+	if err != nil || rounds < 1 {
+		return 0, "", nil, invalid
+	}
+
+	key, err := hex.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return 0, "", nil, invalid
+	}
+
+	return rounds, parts[2], key, nil
+}
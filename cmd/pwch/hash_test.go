@@ -0,0 +1,215 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSplitSchemePrefix(t *testing.T) {
+	testSplit := func(t testing.TB, hash, expectedScheme, expectedHash string) {
+		t.Helper()
+
+		scheme, encoded := splitSchemePrefix(hash)
+		if scheme != expectedScheme {
+			t.Errorf("Expected scheme %s, but got: %s", expectedScheme, scheme)
+		}
+		if encoded != expectedHash {
+			t.Errorf("Expected hash %s, but got: %s", expectedHash, encoded)
+		}
+	}
+
+	// Test case 1
+	t.Run("prefixed hash", func(t *testing.T) {
+		testSplit(t, "{SHA512-CRYPT}$6$salt$hash", "SHA512-CRYPT", "$6$salt$hash")
+	})
+
+	// Test case 2
+	t.Run("lower case prefix", func(t *testing.T) {
+		testSplit(t, "{argon2id}$argon2id$v=19", "ARGON2ID", "$argon2id$v=19")
+	})
+
+	// Test case 3
+	t.Run("hash without prefix", func(t *testing.T) {
+		testSplit(t, "$2y$05$28LTdSX2gZB", defaultPassScheme, "$2y$05$28LTdSX2gZB")
+	})
+}
+
+func TestSHA512Crypt(t *testing.T) {
+	// test vectors from https://www.akkadia.org/drepper/SHA-crypt.txt
+
+	// Test case 1
+	t.Run("default rounds", func(t *testing.T) {
+		got := sha512Crypt("Hello world!", "saltstring", 5000)
+		want := "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"
+
+		if got != want {
+			t.Errorf("\nGot:\n%s\nWant:\n%s", got, want)
+		}
+	})
+
+	// Test case 2
+	t.Run("custom rounds", func(t *testing.T) {
+		got := sha512Crypt("Hello world!", "saltstringsaltstring", 10000)
+		want := "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."
+
+		if got != want {
+			t.Errorf("\nGot:\n%s\nWant:\n%s", got, want)
+		}
+	})
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	testHash := func(t testing.TB, hash string) {
+		t.Helper()
+
+		if !checkPasswordHash("Hello world!", hash) {
+			t.Errorf("Expected %s to match password", hash)
+		}
+		if checkPasswordHash("Hello world?", hash) {
+			t.Errorf("Expected %s not to match wrong password", hash)
+		}
+	}
+
+	// Test case 1
+	t.Run("raw bcrypt hash", func(t *testing.T) {
+		testHash(t, "$2y$05$L82XJz9ZTC3XONRUbi4fwunOL1aC4lVzLBbzArfsje572P3DLvoF6")
+	})
+
+	// Test case 2
+	t.Run("prefixed bcrypt hash", func(t *testing.T) {
+		testHash(t, "{BLF-CRYPT}$2y$05$L82XJz9ZTC3XONRUbi4fwunOL1aC4lVzLBbzArfsje572P3DLvoF6")
+	})
+
+	// Test case 3
+	t.Run("SHA512-CRYPT hash", func(t *testing.T) {
+		testHash(t, "{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1")
+	})
+
+	// Test case 4
+	t.Run("PBKDF2 hash", func(t *testing.T) {
+		testHash(t, "{PBKDF2}$1$abcdefghijklmnop$5000$4d90f3063344d8c0f1d29e88bc272b6f5237d42a")
+	})
+
+	// Test case 5
+	t.Run("unknown scheme", func(t *testing.T) {
+		if checkPasswordHash("Hello world!", "{PLAIN}Hello world!") {
+			t.Error("Expected unsupported scheme not to match")
+		}
+	})
+}
+
+func TestHashPassword(t *testing.T) {
+	cfg.Bcrypt.Cost = 4
+	cfg.Hash.Argon2ID.Memory = 1024
+	cfg.Hash.Argon2ID.Time = 1
+
+	for _, scheme := range []string{"BLF-CRYPT", "SHA512-CRYPT", "ARGON2ID", "PBKDF2"} {
+		t.Run(scheme, func(t *testing.T) {
+			cfg.Hash.Scheme = scheme
+
+			hash, err := hashPassword("StrongPassword123!")
+			if err != nil {
+				t.Fatalf("Expected error to be nil, but got: %v", err)
+			}
+
+			if !strings.HasPrefix(hash, "{"+scheme+"}") {
+				t.Errorf("Expected hash with {%s} prefix, but got: %s", scheme, hash)
+			}
+
+			if !checkPasswordHash("StrongPassword123!", hash) {
+				t.Errorf("Expected generated hash %s to match password", hash)
+			}
+		})
+	}
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		cfg.Hash.Scheme = "PLAIN"
+
+		if _, err := hashPassword("StrongPassword123!"); err == nil {
+			t.Error("Expected error, but got nil")
+		}
+	})
+
+	cfg.Hash.Scheme = ""
+}
+
+func TestHashOutdated(t *testing.T) {
+	cfg.Hash.Scheme = "BLF-CRYPT"
+	cfg.Bcrypt.Cost = 10
+
+	// Test case 1
+	t.Run("lower bcrypt cost", func(t *testing.T) {
+		if !hashOutdated("$2y$05$L82XJz9ZTC3XONRUbi4fwunOL1aC4lVzLBbzArfsje572P3DLvoF6") {
+			t.Error("Expected hash with lower cost to be outdated")
+		}
+	})
+
+	// Test case 2
+	t.Run("other scheme", func(t *testing.T) {
+		if !hashOutdated("{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1") {
+			t.Error("Expected hash with other scheme to be outdated")
+		}
+	})
+
+	// Test case 3
+	t.Run("up to date hash", func(t *testing.T) {
+		cfg.Bcrypt.Cost = 5
+		if hashOutdated("{BLF-CRYPT}$2y$05$L82XJz9ZTC3XONRUbi4fwunOL1aC4lVzLBbzArfsje572P3DLvoF6") {
+			t.Error("Expected hash with configured cost not to be outdated")
+		}
+	})
+
+	// Test case 4
+	t.Run("lower SHA512-CRYPT rounds", func(t *testing.T) {
+		cfg.Hash.Scheme = "SHA512-CRYPT"
+		cfg.Hash.SHA512Crypt.Rounds = 10000
+		if !hashOutdated("{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1") {
+			t.Error("Expected hash with default rounds to be outdated")
+		}
+	})
+
+	cfg.Hash.Scheme = ""
+	cfg.Hash.SHA512Crypt.Rounds = 0
+}
+
+func TestPrintHashReport(t *testing.T) {
+	cfg.Hash.Scheme = "BLF-CRYPT"
+	cfg.Bcrypt.Cost = 10
+
+	// Create a pipe to capture standard output
+	readPipe, writePipe, _ := os.Pipe()
+	defer readPipe.Close()
+
+	// Redirect standard output to the write end of the pipe
+	oldStdout := os.Stdout
+	os.Stdout = writePipe
+
+	printHashReport([]string{
+		"$2y$05$L82XJz9ZTC3XONRUbi4fwunOL1aC4lVzLBbzArfsje572P3DLvoF6",
+		"{BLF-CRYPT}$2y$05$L82XJz9ZTC3XONRUbi4fwunOL1aC4lVzLBbzArfsje572P3DLvoF6",
+		"{PBKDF2}$1$abcdefghijklmnop$5000$4d90f3063344d8c0f1d29e88bc272b6f5237d42a",
+	})
+
+	// Restore standard output
+	os.Stdout = oldStdout
+	writePipe.Close()
+
+	outputBytes, _ := io.ReadAll(readPipe)
+	output := string(outputBytes)
+
+	expected := `Configured:
+  BLF-CRYPT cost=10
+Accounts:
+  3 total, 3 outdated
+Outdated:
+  BLF-CRYPT cost=5 	 2
+  PBKDF2 rounds=5000 	 1`
+
+	if strings.TrimSpace(output) != expected {
+		t.Errorf("Unexpected report.\nExpected:\n%s\nGot:\n%s", expected, output)
+	}
+
+	cfg.Hash.Scheme = ""
+}
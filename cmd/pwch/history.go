@@ -0,0 +1,86 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// password history is disabled unless a retention count is configured,
+// this way installations without the password_history table keep working
+func passwordHistoryEnabled(domain string) bool {
+	return domainConfig(domain).PasswordPolicy.History.Count > 0
+}
+
+// history entries created before the returned time are ignored and pruned
+func passwordHistoryCutoff(domain string) time.Time {
+	maxAge := domainConfig(domain).PasswordPolicy.History.MaxAge
+	if maxAge <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-maxAge)
+}
+
+// fetches the retained password hashes of an account, newest first
+func fetchPasswordHistory(ctx context.Context, tx *sql.Tx, username, domain string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT password FROM password_history
+		WHERE username = $1 AND domain = $2 AND created_at >= $3
+		ORDER BY created_at DESC, id DESC LIMIT $4;`,
+		username, domain, passwordHistoryCutoff(domain), domainConfig(domain).PasswordPolicy.History.Count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// checks whether the password matches any of the given hashes
+func passwordInHistory(password string, hashes []string) bool {
+	for _, hash := range hashes {
+		if checkPasswordHash(password, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// stores the replaced password hash and drops entries exceeding
+// the configured retention count or maximum age
+func recordPasswordHistory(ctx context.Context, tx *sql.Tx, username, domain, hash string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO password_history (username, domain, password) VALUES ($1, $2, $3);",
+		username, domain, hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM password_history
+		WHERE username = $1 AND domain = $2 AND (created_at < $3 OR id NOT IN (
+			SELECT id FROM password_history WHERE username = $1 AND domain = $2
+			ORDER BY created_at DESC, id DESC LIMIT $4));`,
+		username, domain, passwordHistoryCutoff(domain), domainConfig(domain).PasswordPolicy.History.Count)
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPasswordInHistory(t *testing.T) {
+	hashes := []string{
+		"{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		"$2y$05$28LTdSX2gZB/vWBfDNlF9u1W7sJmXM8y4r2lmE4E/UrHI0Fo1YMNK",
+	}
+
+	// Test case 1
+	t.Run("previously used password", func(t *testing.T) {
+		if !passwordInHistory("Hello world!", hashes) {
+			t.Error("Expected password to be found in history")
+		}
+	})
+
+	// Test case 2
+	t.Run("new password", func(t *testing.T) {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stdout)
+
+		if passwordInHistory("Hello world?", hashes) {
+			t.Error("Expected password not to be found in history")
+		}
+		if buf.Len() > 0 {
+			t.Errorf("Expected mismatches not to be logged, but got: %s", buf.String())
+		}
+	})
+
+	// Test case 3
+	t.Run("empty history", func(t *testing.T) {
+		if passwordInHistory("Hello world!", nil) {
+			t.Error("Expected password not to be found in empty history")
+		}
+	})
+}
+
+func TestPasswordHistoryCutoff(t *testing.T) {
+	// Test case 1
+	t.Run("no maximum age", func(t *testing.T) {
+		cfg.PasswordPolicy.History.MaxAge = 0
+		if !passwordHistoryCutoff("localdomain").IsZero() {
+			t.Error("Expected zero cutoff without maximum age")
+		}
+	})
+
+	// Test case 2
+	t.Run("maximum age", func(t *testing.T) {
+		cfg.PasswordPolicy.History.MaxAge = 24 * time.Hour
+		cutoff := passwordHistoryCutoff("localdomain")
+		if time.Since(cutoff) < 24*time.Hour || time.Since(cutoff) > 25*time.Hour {
+			t.Errorf("Unexpected cutoff: %v", cutoff)
+		}
+	})
+
+	cfg.PasswordPolicy.History.MaxAge = 0
+}
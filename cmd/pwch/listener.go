@@ -0,0 +1,289 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// returns the network and address of server.listen, falling back
+// to server.socket_path for configs written before server.listen existed
+func listenAddress(cfg *config) (string, string, error) {
+	listen := cfg.Server.Listen
+	if listen == "" {
+		if cfg.Server.SocketPath == "" {
+			return "", "", errors.New("server.listen is not set")
+		}
+		return "unix", cfg.Server.SocketPath, nil
+	}
+
+	network, address, ok := strings.Cut(listen, "://")
+	if !ok || address == "" || (network != "unix" && network != "tcp") {
+		return "", "", fmt.Errorf("server.listen must be unix:///path or tcp://host:port, got %s", listen)
+	}
+	if network == "tcp" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("server.listen: %v", err)
+		}
+	}
+	return network, address, nil
+}
+
+// parses server.socket_mode, an octal mode like 0660
+func socketMode(cfg *config) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(cfg.Server.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("server.socket_mode must be an octal mode like 0660, got %s", cfg.Server.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func validateServerConfig(cfg *config) error {
+	network, _, err := listenAddress(cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Server.SocketMode != "" {
+		if _, err := socketMode(cfg); err != nil {
+			return err
+		}
+	}
+
+	tlsSettings := cfg.Server.TLS
+	if tlsSettings.CertFile == "" && tlsSettings.KeyFile == "" {
+		if tlsSettings.ClientCAFile != "" {
+			return errors.New("server.tls.client_ca_file requires cert_file and key_file")
+		}
+		return nil
+	}
+	if tlsSettings.CertFile == "" || tlsSettings.KeyFile == "" {
+		return errors.New("server.tls needs both cert_file and key_file")
+	}
+	if network != "tcp" {
+		return errors.New("server.tls requires a tcp:// listen address")
+	}
+	return nil
+}
+
+// holds the current certificate, which is replaced on SIGHUP
+// without dropping established connections
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reads the certificate and key again, the previous pair is
+// kept when the new one can't be loaded
+func (c *certificateReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// TLS 1.2 with forward secret AEAD ciphers only, TLS 1.3 suites
+// aren't configurable and all of them are fine
+func newTLSConfig(reloader *certificateReloader, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// opens the configured listener, or uses the first socket inherited
+// from systemd, wrapped in TLS if certificates are set
+//
+// The returned reloader is nil without TLS.
+func listen(cfg *config, inherited []net.Listener) (net.Listener, *certificateReloader, error) {
+	var listener net.Listener
+	if len(inherited) > 0 {
+		listener = inherited[0]
+	} else {
+		network, address, err := listenAddress(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if network == "unix" {
+			listener, err = listenUnix(cfg, address)
+		} else {
+			listener, err = net.Listen(network, address)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tlsSettings := cfg.Server.TLS
+	if tlsSettings.CertFile == "" {
+		return listener, nil, nil
+	}
+
+	reloader, err := newCertificateReloader(tlsSettings.CertFile, tlsSettings.KeyFile)
+	if err != nil {
+		listener.Close()
+		return nil, nil, err
+	}
+	config, err := newTLSConfig(reloader, tlsSettings.ClientCAFile)
+	if err != nil {
+		listener.Close()
+		return nil, nil, err
+	}
+	return tls.NewListener(listener, config), reloader, nil
+}
+
+// creates the unix socket with the configured group and mode,
+// without socket_mode the mode depends on the umask as before
+//
+// With socket_mode the socket is created with a restrictive umask,
+// so it's never accessible to others before the mode is applied.
+func listenUnix(cfg *config, path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	var mode os.FileMode
+	if cfg.Server.SocketMode != "" {
+		var err error
+		if mode, err = socketMode(cfg); err != nil {
+			return nil, err
+		}
+		oldMask := syscall.Umask(0o177)
+		defer syscall.Umask(oldMask)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if group := cfg.Server.SocketGroup; group != "" {
+		gid, err := lookupGroupID(group)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("server.socket_group: cannot set group %s of %s, pwch has to be a member of it: %w", group, path, err)
+		}
+	}
+
+	if cfg.Server.SocketMode != "" {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// accepts a group name or a numeric group id
+func lookupGroupID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// removes a socket file left behind by a crashed instance
+//
+// The socket is only removed if nothing accepts connections on it,
+// a running instance is never replaced. Other files are left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+
+	log.Print("INFO: Removing stale socket " + path)
+	return os.Remove(path)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writes a certificate and key signed by the parent, or self-signed
+// without parent, and returns the file paths
+func writeTestCertificate(t testing.TB, dir, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (string, string, *x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert, key
+}
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		listen, socketPath string
+		network, address   string
+		valid              bool
+	}{
+		{"", "/run/pwch/pwch.sock", "unix", "/run/pwch/pwch.sock", true},
+		{"unix:///run/pwch/pwch.sock", "", "unix", "/run/pwch/pwch.sock", true},
+		{"tcp://127.0.0.1:8443", "", "tcp", "127.0.0.1:8443", true},
+		{"tcp://127.0.0.1", "", "", "", false},
+		{"http://127.0.0.1:8443", "", "", "", false},
+		{"", "", "", "", false},
+	}
+
+	for _, test := range tests {
+		c := &config{}
+		c.Server.Listen = test.listen
+		c.Server.SocketPath = test.socketPath
+
+		network, address, err := listenAddress(c)
+		if (err == nil) != test.valid || network != test.network || address != test.address {
+			t.Errorf("Unexpected result for %q: %s %s %v", test.listen, network, address, err)
+		}
+	}
+}
+
+func TestValidateServerConfig(t *testing.T) {
+	c := &config{}
+	c.Server.Listen = "unix:///run/pwch/pwch.sock"
+	c.Server.TLS.CertFile = "server.crt"
+	c.Server.TLS.KeyFile = "server.key"
+
+	// Test case 1
+	t.Run("TLS on unix socket", func(t *testing.T) {
+		if err := validateServerConfig(c); err == nil {
+			t.Error("Expected TLS on a unix socket to be rejected")
+		}
+	})
+
+	// Test case 2
+	t.Run("TLS on tcp", func(t *testing.T) {
+		c.Server.Listen = "tcp://127.0.0.1:8443"
+		if err := validateServerConfig(c); err != nil {
+			t.Errorf("Expected error to be nil, but got: %v", err)
+		}
+	})
+
+	// Test case 3
+	t.Run("missing key", func(t *testing.T) {
+		c.Server.TLS.KeyFile = ""
+		if err := validateServerConfig(c); err == nil {
+			t.Error("Expected missing key to be rejected")
+		}
+	})
+}
+
+func TestTLSListener(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _, _ := writeTestCertificate(t, dir, "server", 1, nil, nil)
+	caFile, _, ca, caKey := writeTestCertificate(t, dir, "ca", 2, nil, nil)
+	clientCertFile, clientKeyFile, _, _ := writeTestCertificate(t, dir, "client", 3, ca, caKey)
+
+	c := &config{}
+	c.Server.Listen = "tcp://127.0.0.1:0"
+	c.Server.TLS.CertFile = certFile
+	c.Server.TLS.KeyFile = keyFile
+	c.Server.TLS.ClientCAFile = caFile
+
+	listener, reloader, err := listen(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// completes the handshake of every connection
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(t testing.TB, certificates []tls.Certificate) (*x509.Certificate, error) {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			// the test certificates are self-signed, the served one is checked by serial
+			InsecureSkipVerify: true,
+			Certificates:       certificates,
+			MaxVersion:         tls.VersionTLS12,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		// the server rejects missing client certificates after the handshake
+		// on the client side finished, a read surfaces that error
+		if _, err := conn.Read(make([]byte, 1)); err != nil && err.Error() != "EOF" {
+			return nil, err
+		}
+		return conn.ConnectionState().PeerCertificates[0], nil
+	}
+
+	// Test case 1
+	t.Run("client certificate", func(t *testing.T) {
+		served, err := dial(t, []tls.Certificate{clientCert})
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if served.SerialNumber.Int64() != 1 {
+			t.Errorf("Expected certificate 1, but got: %v", served.SerialNumber)
+		}
+	})
+
+	// Test case 2
+	t.Run("missing client certificate", func(t *testing.T) {
+		if _, err := dial(t, nil); err == nil {
+			t.Error("Expected connection without client certificate to fail")
+		}
+	})
+
+	// Test case 3
+	t.Run("reloaded certificate", func(t *testing.T) {
+		writeTestCertificate(t, dir, "server", 4, nil, nil)
+		if err := reloader.reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		served, err := dial(t, []tls.Certificate{clientCert})
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if served.SerialNumber.Int64() != 4 {
+			t.Errorf("Expected reloaded certificate 4, but got: %v", served.SerialNumber)
+		}
+	})
+
+	// Test case 4
+	t.Run("broken certificate keeps the previous one", func(t *testing.T) {
+		if err := os.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloader.reload(); err == nil {
+			t.Error("Expected reloading a broken certificate to fail")
+		}
+		if served, err := dial(t, []tls.Certificate{clientCert}); err != nil || served.SerialNumber.Int64() != 4 {
+			t.Errorf("Expected previous certificate to be served, got: %v", err)
+		}
+	})
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pwch.sock")
+
+	c := &config{}
+	c.Server.SocketMode = "0640"
+	c.Server.SocketGroup = strconv.Itoa(os.Getgid())
+
+	// Test case 1
+	t.Run("mode and group", func(t *testing.T) {
+		listener, err := listenUnix(c, path)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		defer listener.Close()
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o640 {
+			t.Errorf("Expected mode 0640, but got: %o", info.Mode().Perm())
+		}
+		if gid := info.Sys().(*syscall.Stat_t).Gid; int(gid) != os.Getgid() {
+			t.Errorf("Expected group %d, but got: %d", os.Getgid(), gid)
+		}
+	})
+
+	// Test case 2
+	t.Run("socket in use", func(t *testing.T) {
+		listener, err := listenUnix(c, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		if _, err := listenUnix(c, path); err == nil || !strings.Contains(err.Error(), "in use") {
+			t.Errorf("Expected socket in use to be kept, but got: %v", err)
+		}
+	})
+
+	// Test case 3
+	t.Run("stale socket", func(t *testing.T) {
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// leave the socket file behind like a crashed instance
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		listener.Close()
+
+		listener, err = listenUnix(c, path)
+		if err != nil {
+			t.Fatalf("Expected stale socket to be replaced, but got: %v", err)
+		}
+		listener.Close()
+	})
+
+	// Test case 4
+	t.Run("regular file", func(t *testing.T) {
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if _, err := listenUnix(c, path); err == nil {
+			t.Error("Expected regular file to be kept")
+		}
+	})
+
+	// Test case 5
+	t.Run("invalid mode", func(t *testing.T) {
+		c.Server.Listen = "unix://" + path
+		c.Server.SocketMode = "rw-rw----"
+		if err := validateServerConfig(c); err == nil {
+			t.Error("Expected invalid mode to be rejected")
+		}
+	})
+
+	// Test case 6
+	t.Run("unknown group", func(t *testing.T) {
+		c.Server.SocketMode = ""
+		c.Server.SocketGroup = "pwch-no-such-group"
+		_, err := listenUnix(c, path)
+		if err == nil || !strings.Contains(err.Error(), "pwch-no-such-group") {
+			t.Errorf("Expected error naming the group, but got: %v", err)
+		}
+	})
+}
@@ -26,7 +26,6 @@ import (
 	"fmt"
 	"html/template"
 	"log"
-	"net"
 	"net/http"
 	"net/mail"
 	"net/smtp"
@@ -38,10 +37,8 @@ import (
 	"sync"
 	"syscall"
 	"time"
-	"unicode"
 
 	_ "github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/sha3"
 	"gopkg.in/yaml.v3"
 )
@@ -49,56 +46,128 @@ import (
 var version string
 var configPath = "/etc/pwch/config.yml"
 var cfg config
-var lastEmailSent time.Time
 
 type config struct {
 	Domain     string `yaml:"domain"`
 	URLPrefix  string `yaml:"url_prefix"`
 	AssetsPath string `yaml:"assets_path"`
 	Server     struct {
-		SocketPath string `yaml:"socket_path"`
+		Listen      string `yaml:"listen"`
+		SocketPath  string `yaml:"socket_path"`
+		SocketMode  string `yaml:"socket_mode"`
+		SocketGroup string `yaml:"socket_group"`
+		TLS         struct {
+			CertFile     string `yaml:"cert_file"`
+			KeyFile      string `yaml:"key_file"`
+			ClientCAFile string `yaml:"client_ca_file"`
+		} `yaml:"tls"`
 	} `yaml:"server"`
 	DB struct {
-		Host     string `yaml:"host"`
-		DBName   string `yaml:"db_name"`
-		User     string `yaml:"user"`
-		Password string `yaml:"password"`
-		SSLMode  string `yaml:"ssl_mode"`
+		Host               string `yaml:"host"`
+		DBName             string `yaml:"db_name"`
+		User               string `yaml:"user"`
+		Password           string `yaml:"password"`
+		PasswordFile       string `yaml:"password_file"`
+		PasswordCredential string `yaml:"password_credential"`
+		SSLMode            string `yaml:"ssl_mode"`
 	} `yaml:"db"`
+	Hash struct {
+		Scheme      string `yaml:"scheme"`
+		SHA512Crypt struct {
+			Rounds int `yaml:"rounds"`
+		} `yaml:"sha512_crypt"`
+		Argon2ID struct {
+			Time   uint32 `yaml:"time"`
+			Memory uint32 `yaml:"memory"`
+		} `yaml:"argon2id"`
+		PBKDF2 struct {
+			Rounds int `yaml:"rounds"`
+		} `yaml:"pbkdf2"`
+	} `yaml:"hash"`
 	Bcrypt struct {
 		Cost int `yaml:"cost"`
 	} `yaml:"bcrypt"`
 	SMTP struct {
-		Host          string `yaml:"host"`
-		Port          string `yaml:"port"`
-		LoginUser     string `yaml:"login_user"`
-		LoginPassword string `yaml:"login_password"`
-		Sender        string `yaml:"sender"`
+		Host                    string `yaml:"host"`
+		Port                    string `yaml:"port"`
+		LoginUser               string `yaml:"login_user"`
+		LoginPassword           string `yaml:"login_password"`
+		LoginPasswordFile       string `yaml:"login_password_file"`
+		LoginPasswordCredential string `yaml:"login_password_credential"`
+		Sender                  string `yaml:"sender"`
 	} `yaml:"smtp"`
-	PasswordPolicy struct {
-		MinLength   int  `yaml:"min_length"`
-		MaxLength   int  `yaml:"max_length"`
-		LowerCase   bool `yaml:"lower_case"`
-		UpperCase   bool `yaml:"upper_case"`
-		Digits      bool `yaml:"digits"`
-		SepcialChar bool `yaml:"special_char"`
-	} `yaml:"password_policy"`
-	OTL struct {
-		ValidFor time.Duration `yaml:"valid_for"`
-	} `yaml:"otl"`
+	Passphrase struct {
+		Words     int    `yaml:"words"`
+		Separator string `yaml:"separator"`
+	} `yaml:"passphrase"`
+	ProofOfWork struct {
+		MaxNumber int           `yaml:"max_number"`
+		ValidFor  time.Duration `yaml:"valid_for"`
+	} `yaml:"proof_of_work"`
+	PasswordPolicy  passwordPolicy       `yaml:"password_policy"`
+	OTL             otlSettings          `yaml:"otl"`
+	Branding        branding             `yaml:"branding"`
+	SecurityHeaders securityHeaders      `yaml:"security_headers"`
+	Domains         map[string]yaml.Node `yaml:"domains"`
+	TrustedProxies  []string             `yaml:"trusted_proxies"`
+	ForwardedHeader string               `yaml:"forwarded_header"`
+	domainSettings  map[string]domainSettings
+	trustedProxies  trustedProxies
+}
+
+type passwordPolicy struct {
+	MinLength   int  `yaml:"min_length"`
+	MaxLength   int  `yaml:"max_length"`
+	LowerCase   bool `yaml:"lower_case"`
+	UpperCase   bool `yaml:"upper_case"`
+	Digits      bool `yaml:"digits"`
+	SepcialChar bool `yaml:"special_char"`
+	MinScore    int  `yaml:"min_score"`
+	Breached    struct {
+		Index     string `yaml:"index"`
+		Threshold int    `yaml:"threshold"`
+	} `yaml:"breached"`
+	Blocklist struct {
+		ServiceName string   `yaml:"service_name"`
+		Files       []string `yaml:"files"`
+	} `yaml:"blocklist"`
+	Similarity struct {
+		MinDistance    int  `yaml:"min_distance"`
+		MaxSharedAffix int  `yaml:"max_shared_affix"`
+		DigitsOnly     bool `yaml:"digits_only"`
+	} `yaml:"similarity"`
+	History struct {
+		Count  int           `yaml:"count"`
+		MaxAge time.Duration `yaml:"max_age"`
+	} `yaml:"history"`
+	Expiry struct {
+		MaxAge       time.Duration `yaml:"max_age"`
+		RemindBefore time.Duration `yaml:"remind_before"`
+		RemindEvery  time.Duration `yaml:"remind_every"`
+		LinkValidFor time.Duration `yaml:"link_valid_for"`
+	} `yaml:"expiry"`
+}
+
+type otlSettings struct {
+	ValidFor time.Duration `yaml:"valid_for"`
+}
+
+type branding struct {
+	Title   string `yaml:"title"`
+	LogoURL string `yaml:"logo_url"`
 }
 
 // this is where valid one time URLs are stored
 //
-// key   = random token + username + domain
-// value = time at creation of entry
+// key   = random token
+// value = account and expiry of the link
 //
 // entries are deleted either after the password
 // got changed or when the entry expires
 var oneTimeURLs = struct {
 	sync.RWMutex
-	m map[string]time.Time
-}{m: make(map[string]time.Time)}
+	m map[string]oneTimeLink
+}{m: make(map[string]oneTimeLink)}
 
 // used to fetch account attributes from database
 type mailUser struct {
@@ -107,10 +176,17 @@ type mailUser struct {
 	Domain   string
 }
 
+// data object for the landing page of a one time link
+type redirectTemplateData struct {
+	URLPrefix string
+	Token     string
+}
+
 // data object for html template
 type changePasswordTemplateData struct {
+	Title     string
+	LogoURL   string
 	URLPrefix string
-	Token     string
 	Username  string
 	Domain    string
 	Length    int
@@ -118,10 +194,17 @@ type changePasswordTemplateData struct {
 	Upper     bool
 	Digit     bool
 	Special   bool
+	MinScore  int
+	CSRFToken string
+	CSPNonce  string
+	ExpiresIn string
+	Expired   bool
 }
 
 type submitEmailTemplateData struct {
-	URLPrefix string
+	URLPrefix   string
+	CSPNonce    string
+	ProofOfWork *proofOfWork
 }
 
 func printBuildInfo() {
@@ -144,7 +227,11 @@ func printBuildInfo() {
 
 func printHelp() {
 	fmt.Println(`Possible arguments:
+	--build-breach-index	Build a breach index from a HIBP dump: --build-breach-index <dump> <index>
 	--config		Changes default path from where to read the config file.
+	--dovecot-query		Print a Dovecot password_query denying logins until a forced change is done.
+	--force-change		Force users to change their password: --force-change <user@domain|domain|all>
+	--hash-report		Print how many accounts use outdated password hash parameters.
 	--help			Print this help statement.
 	--version		Print version and build info.`)
 }
@@ -155,22 +242,86 @@ func readFile(cfg *config) error {
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
+	cfg.SecurityHeaders = defaultSecurityHeaders
 	decoder := yaml.NewDecoder(file)
 	err = decoder.Decode(cfg)
 	if err != nil {
 		return err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err = resolveSecrets(cfg, info); err != nil {
+		return err
+	}
+	if err = resolveDomainSettings(cfg); err != nil {
+		return err
+	}
+	if err = parseTrustedProxies(cfg); err != nil {
+		return err
+	}
+	return validateConfig(cfg)
+}
+
+// checks config values that can't be validated by the yaml decoder
+func validateConfig(cfg *config) error {
+	if cfg.Hash.Scheme != "" {
+		if _, ok := passwordSchemes[strings.ToUpper(cfg.Hash.Scheme)]; !ok {
+			return fmt.Errorf("unsupported password scheme %s", cfg.Hash.Scheme)
+		}
+	}
+
+	if err := validateServerConfig(cfg); err != nil {
+		return err
+	}
+
+	if cfg.ProofOfWork.MaxNumber > 0 && cfg.ProofOfWork.ValidFor <= 0 {
+		return errors.New("proof_of_work.valid_for must be positive")
+	}
+
+	if err := validatePasswordPolicy("password_policy", cfg.PasswordPolicy); err != nil {
+		return err
+	}
+	for domain, settings := range cfg.domainSettings {
+		if err := validatePasswordPolicy("domains."+domain+".password_policy", settings.PasswordPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePasswordPolicy(name string, policy passwordPolicy) error {
+	if score := policy.MinScore; score < 0 || score > 4 {
+		return fmt.Errorf("%s.min_score must be between 0 and 4, got %d", name, score)
+	}
+
+	if _, err := blockedWords(policy.Blocklist.Files); err != nil {
+		return err
+	}
+
+	if index := policy.Breached.Index; index != "" {
+		file, err := os.Open(index)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := breachIndexRecords(file); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func deleteFromHashMap(m map[string]time.Time, key string) {
-	oneTimeURLs.RLock()
+func deleteFromHashMap(m map[string]oneTimeLink, key string) {
+	oneTimeURLs.Lock()
 	delete(oneTimeURLs.m, key)
-	oneTimeURLs.RUnlock()
+	oneTimeURLs.Unlock()
 }
 
-func addToHashMap(m map[string]time.Time, key string, value time.Time) {
+func addToHashMap(m map[string]oneTimeLink, key string, value oneTimeLink) {
 	oneTimeURLs.Lock()
 	oneTimeURLs.m[key] = value
 	oneTimeURLs.Unlock()
@@ -196,6 +347,7 @@ func isValidEmail(email string) bool {
 }
 
 func templatePasswordErrorPage(w http.ResponseWriter, errorMessage string) {
+	cfg := loadConfig()
 	tmpl, err := template.ParseFiles(cfg.AssetsPath + "/error.html")
 	if err != nil {
 		log.Print(err)
@@ -207,35 +359,46 @@ func templatePasswordErrorPage(w http.ResponseWriter, errorMessage string) {
 	}
 }
 
-func sendOneTimeLink(username, domain string) {
+func sendOneTimeLink(username, domain, baseURL string) {
+	settings := domainConfig(domain)
+	mailOneTimeLink(username, domain, "Password change requested",
+		"Follow this link to change your password:",
+		"If you did not request a password change then just disregard this message.",
+		settings.OTL.ValidFor, baseURL)
+}
+
+// mails a fresh one time link valid for the given duration,
+// intro and outro are the paragraphs around the link
+func mailOneTimeLink(username, domain, subject, intro, outro string, validFor time.Duration, baseURL string) error {
+	cfg := loadConfig()
 	token, err := genRandomString(64)
 	if err != nil {
 		log.Print(err)
 		log.Print("ERROR: cannot generate random string")
-		return
+		return err
 	}
 
+	settings := domainConfig(domain)
 	loginUser := cfg.SMTP.LoginUser
 	loginPassword := cfg.SMTP.LoginPassword
-	from := cfg.SMTP.Sender
+	from := settings.Sender
 	to := []string{username + "@" + domain}
 	host := cfg.SMTP.Host
 	port := cfg.SMTP.Port
 
-	accessString := "changePassword?token=" + token +
-		"&username=" + username + "&domain=" + domain
+	accessString := "changePassword?token=" + token
 
 	message := []byte("From: " + from + "\r\n" +
 		"To: " + username + "@" + domain + "\r\n" +
-		"Subject: Password change requested\r\n" +
+		"Subject: " + subject + "\r\n" +
 		"\r\n" +
-		"Follow this link to change your password:\r\n" +
+		intro + "\r\n" +
 		"\r\n" +
-		"https://" + cfg.Domain + cfg.URLPrefix + "/" + accessString + "\r\n" +
+		baseURL + "/" + accessString + "\r\n" +
 		"\r\n" +
-		"It's valid for 10 minutes.\r\n" +
+		"It's valid for " + formatValidity(validFor) + ".\r\n" +
 		"\r\n" +
-		"If you did not request a password change then just disregard this message.\r\n")
+		outro + "\r\n")
 
 	auth := smtp.PlainAuth("", loginUser, loginPassword, host)
 
@@ -243,14 +406,20 @@ func sendOneTimeLink(username, domain string) {
 	if err != nil {
 		log.Print(err)
 		log.Print("ERROR: Sending OTL failed")
-		return
+		return err
 	}
 
-	addToHashMap(oneTimeURLs.m, accessString, time.Now())
+	addToHashMap(oneTimeURLs.m, token, oneTimeLink{
+		Username: username,
+		Domain:   domain,
+		Expires:  time.Now().Add(validFor),
+	})
 	log.Print("INFO: Sent OTL to " + username + "@" + domain)
+	return nil
 }
 
 func connectToDatabase() *sql.DB {
+	cfg := loadConfig()
 	var db *sql.DB
 	connStr := "user=" + cfg.DB.User + " password=" + cfg.DB.Password +
 		" dbname=" + cfg.DB.DBName + " host=" + cfg.DB.Host +
@@ -299,21 +468,8 @@ func emailEnabled(email string) (bool, mailUser) {
 	return false, mailUser
 }
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cfg.Bcrypt.Cost)
-	return string(bytes), err
-}
-
-func checkPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil {
-		log.Print(err)
-		return false
-	}
-	return true
-}
-
-func passwordMatches(username, domain, oldPass string) bool {
+// checks the current password and returns the stored hash on success
+func passwordMatches(username, domain, oldPass string) (bool, string) {
 	var db = connectToDatabase()
 
 	var hash string
@@ -321,17 +477,17 @@ func passwordMatches(username, domain, oldPass string) bool {
 		username, domain).Scan(&hash); err != nil {
 		if err == sql.ErrNoRows {
 			_ = closeDatabase(db)
-			return false
+			return false, ""
 		}
 	}
 	_ = closeDatabase(db)
 
 	if checkPasswordHash(oldPass, hash) {
 		log.Print("INFO: Successfully validated old password for " + username + "@" + domain)
-		return true
+		return true, hash
 	}
 	log.Print("ERROR: Can't validate old password for " + username + "@" + domain)
-	return false
+	return false, ""
 }
 
 func reencryptMailbox(username, domain, email, oldPass, newPass string) error {
@@ -393,8 +549,20 @@ func terminateIMAPSessions(email string) error {
 //
 
 func submitEmailHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := requestConfig(r)
 	data := submitEmailTemplateData{
 		URLPrefix: cfg.URLPrefix,
+		CSPNonce:  cspNonce(r),
+	}
+
+	if proofOfWorkEnabled() {
+		challenge, err := issueChallenge()
+		if err != nil {
+			log.Print(err)
+			log.Print("ERROR: cannot issue challenge")
+			return
+		}
+		data.ProofOfWork = &challenge
 	}
 
 	tmpl, err := template.ParseFiles(cfg.AssetsPath + "/submitEmail.html")
@@ -410,53 +578,96 @@ func submitEmailHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func emailSendHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := requestConfig(r)
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	start := time.Now()
 	email := r.FormValue("email")
 	if !isValidEmail(email) {
 		templatePasswordErrorPage(w, "Please enter a valid email address")
 		return
 	}
 
+	if proofOfWorkEnabled() {
+		err := verifyChallenge(r.FormValue("pow-salt"), r.FormValue("pow-challenge"),
+			r.FormValue("pow-signature"), r.FormValue("pow-number"))
+		if err != nil {
+			log.Print(err)
+			log.Print("ERROR: Proof of work rejected from " + clientIP(r))
+			w.WriteHeader(http.StatusForbidden)
+			templatePasswordErrorPage(w, "This form is no longer valid, please reload the page and try again")
+			return
+		}
+	}
+
 	// rate limiting
-	if time.Since(lastEmailSent) < 5*time.Second {
+	if !allowEmailSend(clientIP(r)) {
 		http.Error(w, "Too early. Please try again.", http.StatusTooEarly)
 		return
 	}
 
-	http.ServeFile(w, r, cfg.AssetsPath+"/emailSent.html")
+	// the lookup happens in the background and the response waits for a
+	// fixed time, so neither reveals whether the address exists
+	queueOneTimeLink(otlRequest{Email: email, BaseURL: baseURL(r)})
+	time.Sleep(time.Until(start.Add(emailSendLatency)))
 
-	if enabled, mailUser := emailEnabled(email); enabled {
-		lastEmailSent = time.Now()
-		go sendOneTimeLink(mailUser.Username, mailUser.Domain)
-	}
+	http.ServeFile(w, r, cfg.AssetsPath+"/emailSent.html")
 }
 
+// the one time link lands here with its token, which is posted back and
+// swapped for a session cookie. The form itself is rendered for the
+// session only.
 func passwordChangeHandler(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	username := r.URL.Query().Get("username")
-	domain := r.URL.Query().Get("domain")
-
-	url := fmt.Sprintf("changePassword?token=%s&username=%s&domain=%s", token, username, domain)
+	cfg := requestConfig(r)
+	if r.Method == http.MethodPost {
+		startSession(w, r, r.PostFormValue("token"))
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		showLinkLandingPage(w, r, token)
+		return
+	}
 
-	if _, ok := oneTimeURLs.m[url]; !ok {
+	sessionID, s, ok := sessionFromRequest(r)
+	if !ok {
 		fmt.Fprint(w, "Link expired")
 		return
 	}
+	username, domain := s.Username, s.Domain
 
+	settings := domainConfig(domain)
 	data := changePasswordTemplateData{
+		Title:     settings.Branding.Title,
+		LogoURL:   settings.Branding.LogoURL,
 		URLPrefix: cfg.URLPrefix,
-		Token:     token,
 		Username:  username,
 		Domain:    domain,
-		Length:    cfg.PasswordPolicy.MinLength,
-		Lower:     cfg.PasswordPolicy.LowerCase,
-		Upper:     cfg.PasswordPolicy.UpperCase,
-		Digit:     cfg.PasswordPolicy.Digits,
-		Special:   cfg.PasswordPolicy.SepcialChar,
+		Length:    settings.PasswordPolicy.MinLength,
+		Lower:     settings.PasswordPolicy.LowerCase,
+		Upper:     settings.PasswordPolicy.UpperCase,
+		Digit:     settings.PasswordPolicy.Digits,
+		Special:   settings.PasswordPolicy.SepcialChar,
+		MinScore:  settings.PasswordPolicy.MinScore,
+		CSPNonce:  cspNonce(r),
+	}
+
+	csrfToken, err := issueCSRFToken(sessionID)
+	if err != nil {
+		log.Print(err)
+		log.Print("ERROR: cannot generate CSRF token")
+		return
+	}
+	data.CSRFToken = csrfToken
+
+	if expiresAt, ok := passwordExpiresAt(username, domain); ok {
+		if remaining := time.Until(expiresAt); remaining > 0 {
+			data.ExpiresIn = formatRemaining(remaining)
+		} else {
+			data.Expired = true
+		}
 	}
 
 	tmpl, err := template.ParseFiles(cfg.AssetsPath + "/changePassword.html")
@@ -471,28 +682,82 @@ func passwordChangeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func passwordSubmitHandler(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	username := r.URL.Query().Get("username")
-	domain := r.URL.Query().Get("domain")
+// shows a Continue button that posts the token of a one time link
+//
+// Opening the link doesn't consume the token, mail scanners fetching
+// every link in a message would otherwise use it up before the user
+// gets to click it.
+func showLinkLandingPage(w http.ResponseWriter, r *http.Request, token string) {
+	cfg := requestConfig(r)
+	if _, ok := lookupOneTimeLink(token); !ok {
+		fmt.Fprint(w, "Link expired")
+		return
+	}
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	tmpl, err := template.ParseFiles(cfg.AssetsPath + "/redirect.html")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if err := tmpl.Execute(w, redirectTemplateData{URLPrefix: cfg.URLPrefix, Token: token}); err != nil {
+		log.Print(err)
+		log.Print("ERROR: cannot execute template")
+	}
+}
+
+// swaps the posted token of a one time link for a session cookie and
+// redirects to the clean URL of the change page
+//
+// The post is sent by the landing page, so the redirect is part of a
+// same-site navigation and browsers send the SameSite=Strict cookie.
+func startSession(w http.ResponseWriter, r *http.Request, token string) {
+	cfg := requestConfig(r)
+	link, ok := consumeOneTimeLink(token)
+	if !ok {
+		fmt.Fprint(w, "Link expired")
+		return
+	}
+
+	sessionID, expires, err := createSession(link)
+	if err != nil {
+		log.Print(err)
+		log.Print("ERROR: cannot create session")
+		return
+	}
+	setSessionCookie(w, sessionID, expires)
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.Redirect(w, r, cfg.URLPrefix+"/changePassword", http.StatusSeeOther)
+}
 
+func passwordSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := requestConfig(r)
 	oldPass := r.FormValue("current-password")
 	newPass := r.FormValue("new-password")
 	confirmPass := r.FormValue("confirm-password")
 
-	url := fmt.Sprintf("changePassword?token=%s&username=%s&domain=%s", token, username, domain)
-
-	if _, ok := oneTimeURLs.m[url]; !ok {
+	sessionID, s, ok := sessionFromRequest(r)
+	if !ok {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
+	username, domain := s.Username, s.Domain
 
-	if err := validatePasswordFields(newPass, confirmPass, oldPass); err != nil {
+	if !sameOriginRequest(r) || !validCSRFToken(sessionID, r.FormValue("csrf-token")) {
+		log.Print("ERROR: Rejected password change with invalid CSRF token for " + username + "@" + domain +
+			" from " + clientIP(r))
+		w.WriteHeader(http.StatusForbidden)
+		templatePasswordErrorPage(w, "This form is no longer valid, please reload the page and try again")
+		return
+	}
+
+	if err := validatePasswordFields(newPass, confirmPass, oldPass, domain); err != nil {
 		templatePasswordErrorPage(w, err.Error())
 		return
 	}
 
-	if enforced, errMessage := enforcePasswordPolicy(newPass); enforced == false {
+	if enforced, errMessage := enforcePasswordPolicy(newPass, username, domain); enforced == false {
 		templatePasswordErrorPage(w, errMessage)
 		return
 	}
@@ -502,12 +767,13 @@ func passwordSubmitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteFromHashMap(oneTimeURLs.m, url)
-	log.Print("INFO: Deleted " + url + " from map")
+	deleteSession(sessionID)
+	clearSessionCookie(w)
+	log.Print("INFO: Deleted session for " + username + "@" + domain)
 	http.ServeFile(w, r, cfg.AssetsPath+"/success.html")
 }
 
-func validatePasswordFields(newPass, confirmPass, oldPass string) error {
+func validatePasswordFields(newPass, confirmPass, oldPass, domain string) error {
 	if newPass != confirmPass {
 		return errors.New("Passwords do not match")
 	}
@@ -516,59 +782,20 @@ func validatePasswordFields(newPass, confirmPass, oldPass string) error {
 		return errors.New("You are trying to set the same password again")
 	}
 
-	return nil
-}
-
-func enforcePasswordPolicy(password string) (bool, string) {
-	if len(password) < cfg.PasswordPolicy.MinLength {
-		return false, fmt.Sprintf("Please enter at least a %d character long password", cfg.PasswordPolicy.MinLength)
-	}
-
-	if len(password) > cfg.PasswordPolicy.MaxLength {
-		return false, fmt.Sprintf("Please enter at max a %d character long password", cfg.PasswordPolicy.MaxLength)
-	}
-
-	// initialize with opposite of config value
-	// policies set to "false" will therefore init with "true" and will not change
-	hasLower := !cfg.PasswordPolicy.LowerCase
-	hasUpper := !cfg.PasswordPolicy.UpperCase
-	hasNumber := !cfg.PasswordPolicy.Digits
-	hasSpecial := !cfg.PasswordPolicy.SepcialChar
-
-	for _, char := range password {
-		switch {
-		case unicode.IsNumber(char):
-			hasNumber = true
-		case unicode.IsLower(char):
-			hasLower = true
-		case unicode.IsUpper(char):
-			hasUpper = true
-		case unicode.IsPunct(char), unicode.IsSpace(char), unicode.IsSymbol(char):
-			hasSpecial = true
-		}
+	if passwordsTooSimilar(oldPass, newPass, domain) {
+		return errors.New("Your new password is too similar to your current one")
 	}
 
-	if hasLower && hasUpper && hasNumber && hasSpecial {
-		return true, "Success"
-	}
-
-	errorMessage := "Undefined error"
-	if !hasLower {
-		errorMessage = "Please enter at least one lower case character"
-	} else if !hasUpper {
-		errorMessage = "Please enter at least one upper case character"
-	} else if !hasNumber {
-		errorMessage = "Please enter at least one digit"
-	} else if !hasSpecial {
-		errorMessage = "Please enter at least one special character"
-	}
-
-	return false, errorMessage
+	return nil
 }
 
 // updates password in database, reencrypts mailbox and terminates IMAP sessions
 func updatePassword(username, domain, newPass, oldPass string) error {
-	if !passwordMatches(username, domain, oldPass) {
+	inFlight.Add(1)
+	defer inFlight.Done()
+
+	matches, oldHash := passwordMatches(username, domain, oldPass)
+	if !matches {
 		return errors.New("Current Password does not match")
 	}
 
@@ -589,7 +816,24 @@ func updatePassword(username, domain, newPass, oldPass string) error {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx, "UPDATE accounts SET password = $1 WHERE username = $2 AND domain = $3;",
+	if passwordHistoryEnabled(domain) {
+		history, err := fetchPasswordHistory(ctx, tx, username, domain)
+		if err != nil {
+			log.Print("ERROR: password history query failed")
+			return err
+		}
+		if passwordInHistory(newPass, history) {
+			log.Print("INFO: Rejected previously used password for " + username + "@" + domain)
+			return errors.New("You have used this password before, please choose a different one")
+		}
+		if err = recordPasswordHistory(ctx, tx, username, domain, oldHash); err != nil {
+			log.Print("ERROR: password history update failed")
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE accounts SET password = $1, password_changed_at = now(), "+
+		"password_reminder_sent_at = NULL, must_change_password = false WHERE username = $2 AND domain = $3;",
 		string(hash), username, domain)
 	if err != nil {
 		log.Print("ERROR: password update query failed")
@@ -608,6 +852,10 @@ func updatePassword(username, domain, newPass, oldPass string) error {
 	}
 
 	log.Print("INFO: Password successfully changed for " + email)
+	if hashOutdated(oldHash) {
+		log.Printf("INFO: Upgraded password hash for %s from %s to %s",
+			email, hashParameters(oldHash), configuredHashParameters())
+	}
 	err = terminateIMAPSessions(email)
 
 	return err
@@ -628,6 +876,20 @@ func main() {
 				os.Exit(0)
 			}
 		}
+		for i, arg := range os.Args {
+			if arg == "--build-breach-index" && len(os.Args) > i+2 {
+				if err := buildBreachIndex(os.Args[i+1], os.Args[i+2]); err != nil {
+					log.Fatal(err)
+				}
+				os.Exit(0)
+			}
+		}
+		for _, arg := range os.Args {
+			if arg == "--dovecot-query" {
+				printDovecotQuery()
+				os.Exit(0)
+			}
+		}
 		if os.Args[1] == "--config" {
 			configPath = os.Args[2]
 		}
@@ -638,7 +900,41 @@ func main() {
 		log.Fatal(err)
 	}
 
-	lastEmailSent = time.Now()
+	for _, arg := range os.Args {
+		if arg == "--hash-report" {
+			hashes, err := fetchPasswordHashes()
+			if err != nil {
+				log.Fatal(err)
+			}
+			printHashReport(hashes)
+			os.Exit(0)
+		}
+	}
+
+	for i, arg := range os.Args {
+		if arg == "--force-change" && len(os.Args) > i+1 {
+			count, err := forcePasswordChange(os.Args[i+1])
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Flagged %d accounts to change their password\n", count)
+			os.Exit(0)
+		}
+	}
+
+	if err := initChallengeKey(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	inFlight.Add(1)
+	go func() {
+		defer inFlight.Done()
+		processOneTimeLinkRequests(workerCtx)
+	}()
 
 	mux := http.NewServeMux()
 
@@ -646,43 +942,88 @@ func main() {
 	mux.HandleFunc(cfg.URLPrefix+"/emailSend", emailSendHandler)
 	mux.HandleFunc(cfg.URLPrefix+"/changePassword", passwordChangeHandler)
 	mux.HandleFunc(cfg.URLPrefix+"/submitPassword", passwordSubmitHandler)
+	mux.HandleFunc(cfg.URLPrefix+"/api/policy", policyAPIHandler)
+	mux.HandleFunc(cfg.URLPrefix+"/api/policy/check", policyCheckAPIHandler)
+	mux.HandleFunc(cfg.URLPrefix+"/api/passphrase", passphraseAPIHandler)
 
-	socket, err := net.Listen("unix", cfg.Server.SocketPath)
+	inherited, err := systemdListeners()
 	if err != nil {
 		log.Fatal(err)
 	}
+	socket, reloader, err := listen(&cfg, inherited)
+	if err != nil {
+		log.Fatal(err)
+	}
+	network, address, _ := listenAddress(&cfg)
+	if len(inherited) > 0 {
+		network, address = "systemd", socket.Addr().String()
+	}
 
-	// Cleanup the sockfile.
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		if err := os.Remove(cfg.Server.SocketPath); err != nil {
-			log.Fatal(err)
-		}
-		os.Exit(0)
-	}()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadOnSignal(reloader, hup)
 
 	server := http.Server{
-		Handler:      mux,
+		Handler:      withConfigSnapshot(withSecurityHeaders(mux)),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
 	log.Printf("pwch %s", version)
-	log.Print("INFO: Listening on " + cfg.Server.SocketPath)
+	log.Print("INFO: Listening on " + network + "://" + address)
+	// closing the listener on shutdown removes the sockfile,
+	// sockets passed by systemd are left to systemd
 	go func() {
-		log.Fatal(server.Serve(socket))
+		if err := server.Serve(socket); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
 	}()
 
+	if err := sdNotify("READY=1"); err != nil {
+		log.Print(err)
+		log.Print("ERROR: cannot notify systemd")
+	}
+	// the watchdog is pinged from this loop and only if requests are
+	// still served, a hang of either makes systemd restart pwch
+	interval := watchdogInterval()
+	var watchdog <-chan time.Time
+	if interval > 0 {
+		watchdogTicker := time.NewTicker(interval)
+		defer watchdogTicker.Stop()
+		watchdog = watchdogTicker.C
+	}
+
 	ticker := time.NewTicker(30 * time.Second)
+	var lastReminderCheck time.Time
 	for {
-		<-ticker.C
-		for k, v := range oneTimeURLs.m {
-			if time.Now().Sub(v) > cfg.OTL.ValidFor {
-				deleteFromHashMap(oneTimeURLs.m, k)
-				log.Print("INFO: Deleted expired route " + k + " from map")
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			shutdown(&server, stopWorker)
+			return
+		case <-watchdog:
+			if !servesRequests(server.Handler, cfg.URLPrefix+"/api/policy", interval/2) {
+				log.Print("ERROR: Liveness check failed, not pinging the watchdog")
+				continue
 			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Print(err)
+				log.Print("ERROR: cannot notify systemd")
+			}
+			continue
+		case <-ticker.C:
+		}
+		expireOneTimeLinks()
+		expireChallenges()
+		expireRateLimits()
+
+		if time.Since(lastReminderCheck) >= reminderCheckInterval {
+			lastReminderCheck = time.Now()
+			inFlight.Add(1)
+			go func() {
+				defer inFlight.Done()
+				sendPasswordReminders()
+			}()
 		}
 	}
 }
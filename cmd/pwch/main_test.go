@@ -12,6 +12,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -71,7 +72,11 @@ func TestPrintHelp(t *testing.T) {
 	output := string(outputBytes)
 
 	expectedHelp := `Possible arguments:
+	--build-breach-index	Build a breach index from a HIBP dump: --build-breach-index <dump> <index>
 	--config		Changes default path from where to read the config file.
+	--dovecot-query		Print a Dovecot password_query denying logins until a forced change is done.
+	--force-change		Force users to change their password: --force-change <user@domain|domain|all>
+	--hash-report		Print how many accounts use outdated password hash parameters.
 	--help			Print this help statement.
 	--version		Print version and build info.`
 
@@ -110,7 +115,18 @@ func TestReadFile(t *testing.T) {
 
 	// Test case 3
 	t.Run("test successful case", func(t *testing.T) {
-		err := loadConfig(t, "../../config/config.yml")
+		// the sample config contains inline secrets, so it
+		// must not be readable by everyone, see the README
+		sample, err := os.ReadFile("../../config/config.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "config.yml")
+		if err := os.WriteFile(path, sample, 0640); err != nil {
+			t.Fatal(err)
+		}
+
+		err = loadConfig(t, path)
 
 		if err != nil {
 			t.Errorf("Expected error to be nil, but got: %v", err)
@@ -120,13 +136,16 @@ func TestReadFile(t *testing.T) {
 		if cfg.Domain != expectedDomain {
 			t.Errorf("Expected Domain: %s, Got: %s", expectedDomain, cfg.Domain)
 		}
+		if cfg.DB.Password != "vmail_password" || cfg.SMTP.LoginPassword != "noreply_password" {
+			t.Errorf("Expected inline secrets, Got: %s %s", cfg.DB.Password, cfg.SMTP.LoginPassword)
+		}
 	})
 }
 
 func TestDeleteFromHashMap(t *testing.T) {
 	m := oneTimeURLs.m
 	key := "test_key"
-	value := time.Now()
+	value := oneTimeLink{Username: "pwch1", Domain: "localdomain", Expires: time.Now()}
 
 	// Add a value to the map
 	m[key] = value
@@ -143,7 +162,7 @@ func TestDeleteFromHashMap(t *testing.T) {
 func TestAddToHashMap(t *testing.T) {
 	m := oneTimeURLs.m
 	key := "test_key"
-	value := time.Now()
+	value := oneTimeLink{Username: "pwch1", Domain: "localdomain", Expires: time.Now()}
 
 	// Call the function to add a key-value pair to the map
 	addToHashMap(m, key, value)
@@ -216,7 +235,7 @@ func TestSendOneTimeLink(t *testing.T) {
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
 
-		sendOneTimeLink(username, domain)
+		sendOneTimeLink(username, domain, defaultBaseURL())
 
 		// Get the log output from the buffer
 		output := buf.String()
@@ -252,97 +271,20 @@ func TestSendOneTimeLink(t *testing.T) {
 	})
 }
 
-func TestEnforcePasswordPolicy(t *testing.T) {
-
-	cfg.PasswordPolicy.MinLength = 12
-	cfg.PasswordPolicy.MaxLength = 24
-	cfg.PasswordPolicy.LowerCase = true
-	cfg.PasswordPolicy.UpperCase = true
-	cfg.PasswordPolicy.Digits = true
-	cfg.PasswordPolicy.SepcialChar = true
-
-	testPassword := func(t testing.TB, password, expectedMessage string) {
-		t.Helper()
-
-		valid, message := enforcePasswordPolicy(password)
-
-		if valid {
-			t.Errorf("Expected invalid password for input '%s', but got valid", password)
-		}
-
-		if message != expectedMessage {
-			t.Errorf("Expected error message '%s', but got: %s", expectedMessage, message)
-		}
-
-	}
-
-	// Test case 1: Valid password that meets all requirements
-	password := "StrongPassword123!"
-	valid, message := enforcePasswordPolicy(password)
-	if !valid {
-		t.Errorf("Expected valid password for input '%s', but got invalid", password)
-	}
-	if message != "Success" {
-		t.Errorf("Expected success message for valid password, but got: %s", message)
-	}
-
-	// Test case 2
-	t.Run("password of insufficient length", func(t *testing.T) {
-		password = "short"
-		expectedMessage := fmt.Sprintf("Please enter at least a %d character long password", cfg.PasswordPolicy.MinLength)
-		testPassword(t, password, expectedMessage)
-	})
-
-	// Test case 3
-	t.Run("password exceeding maximum length", func(t *testing.T) {
-		password = "thispasswordexceedsthemaximumallowedlength"
-		expectedMessage := fmt.Sprintf("Please enter at max a %d character long password", cfg.PasswordPolicy.MaxLength)
-		testPassword(t, password, expectedMessage)
-	})
-
-	// Test case 4
-	t.Run("password missing lower case character", func(t *testing.T) {
-		password = "PASSWORD123!"
-		expectedMessage := "Please enter at least one lower case character"
-		testPassword(t, password, expectedMessage)
-	})
-
-	// Test case 5
-	t.Run("password missing upper case character", func(t *testing.T) {
-		password = "password123!"
-		expectedMessage := "Please enter at least one upper case character"
-		testPassword(t, password, expectedMessage)
-	})
-
-	// Test case 6
-	t.Run("password missing digit", func(t *testing.T) {
-		password = "PasswordWithoutDigit!"
-		expectedMessage := "Please enter at least one digit"
-		testPassword(t, password, expectedMessage)
-	})
-
-	// Test case 7
-	t.Run("password missing special character", func(t *testing.T) {
-		password = "PasswordNoSpecial123"
-		expectedMessage := "Please enter at least one special character"
-		testPassword(t, password, expectedMessage)
-	})
-}
-
 func TestValidatePasswordFields(t *testing.T) {
 	newPass := "newPassword"
 	confirmPass := "newPassword"
 	oldPass := "oldPassword"
 
 	// Test case 1: Valid input
-	err := validatePasswordFields(newPass, confirmPass, oldPass)
+	err := validatePasswordFields(newPass, confirmPass, oldPass, "localdomain")
 	if err != nil {
 		t.Errorf("Expected no error for valid input, but got: %s", err.Error())
 	}
 
 	// Test case 2: Mismatched passwords
 	confirmPass = "wrongPassword"
-	err = validatePasswordFields(newPass, confirmPass, oldPass)
+	err = validatePasswordFields(newPass, confirmPass, oldPass, "localdomain")
 	if err == nil {
 		t.Error("Expected error for mismatched passwords, but got no error")
 	} else if err.Error() != "Passwords do not match" {
@@ -352,12 +294,24 @@ func TestValidatePasswordFields(t *testing.T) {
 	// Test case 3: Same old and new passwords
 	newPass = "oldPassword"
 	confirmPass = "oldPassword"
-	err = validatePasswordFields(newPass, confirmPass, oldPass)
+	err = validatePasswordFields(newPass, confirmPass, oldPass, "localdomain")
 	if err == nil {
 		t.Error("Expected error for setting same password again, but got no error")
 	} else if err.Error() != "You are trying to set the same password again" {
 		t.Errorf("Expected error message 'You are trying to set the same password again', but got: %s", err.Error())
 	}
+
+	// Test case 4: New password too similar to the old one
+	cfg.PasswordPolicy.Similarity.DigitsOnly = true
+	newPass = "oldPassword2"
+	confirmPass = "oldPassword2"
+	err = validatePasswordFields(newPass, confirmPass, oldPass, "localdomain")
+	if err == nil {
+		t.Error("Expected error for similar passwords, but got no error")
+	} else if err.Error() != "Your new password is too similar to your current one" {
+		t.Errorf("Expected error message 'Your new password is too similar to your current one', but got: %s", err.Error())
+	}
+	cfg.PasswordPolicy.Similarity.DigitsOnly = false
 }
 
 func TestReencryptMailbox(t *testing.T) {
@@ -515,8 +469,9 @@ func TestEmailSendHandler(t *testing.T) {
 
 		emailSendHandler(rr, req)
 
+		// do the lookup and send of the worker
 		if pause {
-			time.Sleep(500 * time.Millisecond)
+			handleOneTimeLinkRequest(<-otlRequests)
 		}
 		output := buf.String()
 
@@ -556,12 +511,12 @@ func TestEmailSendHandler(t *testing.T) {
 
 	// Test case 2
 	// reset rate limiting
-	lastEmailSent = time.Now().Add(-10 * time.Minute)
+	lastEmailSent.m = make(map[string]time.Time)
 	form = url.Values{}
 	t.Run("test invalid email address", func(t *testing.T) {
 		form.Add("email", "invalid@localdomain")
 
-		logOutput := checkEmailAddress(t, "an email may have been sent", "POST", http.StatusOK, false)
+		logOutput := checkEmailAddress(t, "an email may have been sent", "POST", http.StatusOK, true)
 
 		expected := "INFO: Unknown email address: invalid@localdomain\n"
 
@@ -571,7 +526,8 @@ func TestEmailSendHandler(t *testing.T) {
 	})
 
 	// Test case 3
-	lastEmailSent = time.Now()
+	// requests without remote address come in over the unix socket
+	lastEmailSent.m["unix"] = time.Now()
 	form = url.Values{}
 	t.Run("test rate limiting", func(t *testing.T) {
 		form.Add("email", "pwch1@localdomain")
@@ -596,13 +552,14 @@ func TestEmailSendHandler(t *testing.T) {
 func TestPasswordChangeHandler(t *testing.T) {
 	cfg.AssetsPath = "../../assets/html"
 
-	getResetPage := func(t testing.TB, url, expected string) {
+	getResetPage := func(t testing.TB, sessionID, expected string) {
 		t.Helper()
 
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequest("GET", "/changePassword", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionID})
 
 		rr := httptest.NewRecorder()
 
@@ -622,20 +579,17 @@ func TestPasswordChangeHandler(t *testing.T) {
 	}
 
 	// Test case 1
-	t.Run("test with valid URL", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
-		addToHashMap(oneTimeURLs.m, url, time.Now())
+	t.Run("test with valid session", func(t *testing.T) {
+		sessionID := newTestSession(t, "pwch1", "localdomain")
 
-		getResetPage(t, url, "<title>Password Reset</title>")
+		getResetPage(t, sessionID, "<title>Password Reset</title>")
 	})
 
 	// Test case 2
-	t.Run("test with invalid URL", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
+	t.Run("test with invalid session", func(t *testing.T) {
+		sessionID, _ := genRandomString(32)
 
-		getResetPage(t, url, "Link expired")
+		getResetPage(t, sessionID, "Link expired")
 	})
 }
 
@@ -655,13 +609,16 @@ func TestPasswordSubmitHandler(t *testing.T) {
 
 	form := url.Values{}
 
-	getResultPage := func(t testing.TB, url, expectedBody string, expectedCode int) {
+	getResultPage := func(t testing.TB, sessionID, expectedBody string, expectedCode int) {
 		t.Helper()
 
-		req, err := http.NewRequest("POST", "/submitPassword/"+url, nil)
+		req, err := http.NewRequest("POST", "/submitPassword", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionID})
+		csrfToken, _ := issueCSRFToken(sessionID)
+		form.Set("csrf-token", csrfToken)
 		req.PostForm = form
 
 		rr := httptest.NewRecorder()
@@ -682,91 +639,78 @@ func TestPasswordSubmitHandler(t *testing.T) {
 
 	// Test case 1
 	t.Run("test full workflow", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
-		addToHashMap(oneTimeURLs.m, url, time.Now())
+		sessionID := newTestSession(t, "pwch1", "localdomain")
 
 		form.Add("current-password", "password")
 		form.Add("new-password", "StrongPassword123!")
 		form.Add("confirm-password", "StrongPassword123!")
 
-		getResultPage(t, url, "Success", http.StatusOK)
+		getResultPage(t, sessionID, "Success", http.StatusOK)
 	})
 
 	// Test case 2
 	t.Run("test workflow again with same credentials and fail", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
-		addToHashMap(oneTimeURLs.m, url, time.Now())
+		sessionID := newTestSession(t, "pwch1", "localdomain")
 
 		form.Add("current-password", "password")
 		form.Add("new-password", "StrongPassword123!")
 		form.Add("confirm-password", "StrongPassword123!")
 
-		getResultPage(t, url, "Current Password does not match", http.StatusOK)
+		getResultPage(t, sessionID, "Current Password does not match", http.StatusOK)
 	})
 
 	// Test case 3
 	form = url.Values{}
 	t.Run("test redirect for expired link", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
+		sessionID, _ := genRandomString(32)
 
 		form.Add("current-password", "StrongPassword1234!")
 		form.Add("new-password", "StrongPassword123!+")
 		form.Add("confirm-password", "StrongPassword123!+")
 
-		getResultPage(t, url, "", http.StatusFound)
+		getResultPage(t, sessionID, "", http.StatusFound)
 	})
 
 	// Test case 4
 	form = url.Values{}
 	t.Run("test missmatching passwords", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
-		addToHashMap(oneTimeURLs.m, url, time.Now())
+		sessionID := newTestSession(t, "pwch1", "localdomain")
 
 		form.Add("current-password", "password")
 		form.Add("new-password", "StrongPassword1234!")
 		form.Add("confirm-password", "StrongPassword123!")
 
-		getResultPage(t, url, "Passwords do not match", http.StatusOK)
+		getResultPage(t, sessionID, "Passwords do not match", http.StatusOK)
 	})
 
 	// Test case 5
 	form = url.Values{}
 	t.Run("test setting the same password", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
-		addToHashMap(oneTimeURLs.m, url, time.Now())
+		sessionID := newTestSession(t, "pwch1", "localdomain")
 
 		form.Add("current-password", "password")
 		form.Add("new-password", "password")
 		form.Add("confirm-password", "password")
 
-		getResultPage(t, url, "You are trying to set the same password again", http.StatusOK)
+		getResultPage(t, sessionID, "You are trying to set the same password again", http.StatusOK)
 	})
 
 	// Test case 6
 	form = url.Values{}
 	t.Run("test password policy violation", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
-		addToHashMap(oneTimeURLs.m, url, time.Now())
+		sessionID := newTestSession(t, "pwch1", "localdomain")
 
 		form.Add("current-password", "password")
 		form.Add("new-password", "password123")
 		form.Add("confirm-password", "password123")
 
-		getResultPage(t, url, "Please enter at least one upper case character", http.StatusOK)
+		getResultPage(t, sessionID, "Please enter at least one upper case character", http.StatusOK)
 	})
 
 	// Test case 7
 	form = url.Values{}
 	t.Run("revert test case 1", func(t *testing.T) {
-		token, _ := genRandomString(64)
-		url := "changePassword?token=" + token + "&username=pwch1&domain=localdomain"
-		addToHashMap(oneTimeURLs.m, url, time.Now())
+		sessionID := newTestSession(t, "pwch1", "localdomain")
 
 		form.Add("current-password", "StrongPassword123!")
 		form.Add("new-password", "password")
@@ -774,7 +718,47 @@ func TestPasswordSubmitHandler(t *testing.T) {
 
 		cfg.PasswordPolicy.UpperCase = false
 
-		getResultPage(t, url, "Success", http.StatusOK)
+		getResultPage(t, sessionID, "Success", http.StatusOK)
+	})
+
+	// Test case 8
+	form = url.Values{}
+	t.Run("test password history", func(t *testing.T) {
+		cfg.PasswordPolicy.History.Count = 2
+
+		sessionID := newTestSession(t, "pwch2", "localdomain")
+
+		form.Add("current-password", "password")
+		form.Add("new-password", "StrongPassword123!")
+		form.Add("confirm-password", "StrongPassword123!")
+
+		getResultPage(t, sessionID, "Success", http.StatusOK)
+	})
+
+	// Test case 9
+	form = url.Values{}
+	t.Run("test reusing a password from history", func(t *testing.T) {
+		sessionID := newTestSession(t, "pwch2", "localdomain")
+
+		form.Add("current-password", "StrongPassword123!")
+		form.Add("new-password", "password")
+		form.Add("confirm-password", "password")
+
+		getResultPage(t, sessionID, "You have used this password before", http.StatusOK)
+	})
+
+	// Test case 10
+	form = url.Values{}
+	t.Run("revert test case 8", func(t *testing.T) {
+		cfg.PasswordPolicy.History.Count = 0
+
+		sessionID := newTestSession(t, "pwch2", "localdomain")
+
+		form.Add("current-password", "StrongPassword123!")
+		form.Add("new-password", "password")
+		form.Add("confirm-password", "password")
+
+		getResultPage(t, sessionID, "Success", http.StatusOK)
 	})
 
 	// restore log output to stdout
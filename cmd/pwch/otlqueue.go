@@ -0,0 +1,115 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// maximum number of one time link requests waiting for the worker,
+// further requests are dropped until the worker catches up
+const otlQueueSize = 100
+
+// every accepted request to /emailSend is answered after this duration,
+// regardless of whether the address exists
+var emailSendLatency = 500 * time.Millisecond
+
+// minimum time between two submissions of the same client
+const emailSendInterval = 5 * time.Second
+
+// an address waiting to be looked up and sent a one time link,
+// the link points to the URL the request was made to
+type otlRequest struct {
+	Email   string
+	BaseURL string
+}
+
+var otlRequests = make(chan otlRequest, otlQueueSize)
+
+// time of the last submission by client address
+var lastEmailSent = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// hands the address to the worker without blocking the request
+func queueOneTimeLink(request otlRequest) {
+	select {
+	case otlRequests <- request:
+	default:
+		log.Print("ERROR: One time link queue full, dropped request for " + request.Email)
+	}
+}
+
+// looks up queued addresses and mails one time links to enabled accounts,
+// this keeps the database and SMTP round trips off the request path
+//
+// Once the context is cancelled the requests still queued are
+// handled before returning.
+func processOneTimeLinkRequests(ctx context.Context) {
+	for {
+		select {
+		case request := <-otlRequests:
+			handleOneTimeLinkRequest(request)
+		case <-ctx.Done():
+			for {
+				select {
+				case request := <-otlRequests:
+					handleOneTimeLinkRequest(request)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func handleOneTimeLinkRequest(request otlRequest) {
+	inFlight.Add(1)
+	defer inFlight.Done()
+
+	if enabled, mailUser := emailEnabled(request.Email); enabled {
+		sendOneTimeLink(mailUser.Username, mailUser.Domain, request.BaseURL)
+	}
+}
+
+// applies the rate limit to every submission of a client, known address
+// or not, so the limit itself doesn't reveal which addresses exist
+func allowEmailSend(client string) bool {
+	lastEmailSent.Lock()
+	defer lastEmailSent.Unlock()
+
+	if time.Since(lastEmailSent.m[client]) < emailSendInterval {
+		return false
+	}
+	lastEmailSent.m[client] = time.Now()
+	return true
+}
+
+// forgets clients whose last submission is longer ago than the interval
+func expireRateLimits() {
+	lastEmailSent.Lock()
+	defer lastEmailSent.Unlock()
+
+	for client, sent := range lastEmailSent.m {
+		if time.Since(sent) >= emailSendInterval {
+			delete(lastEmailSent.m, client)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailSendTiming(t *testing.T) {
+	cfg.AssetsPath = "../../assets/html"
+	emailSendLatency = 100 * time.Millisecond
+
+	submit := func(t testing.TB, email string) (time.Duration, *httptest.ResponseRecorder) {
+		t.Helper()
+
+		// reset rate limiting
+		lastEmailSent.m = make(map[string]time.Time)
+
+		form := url.Values{}
+		form.Add("email", email)
+		req, err := http.NewRequest("POST", "/emailSend", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		emailSendHandler(rr, req)
+		elapsed := time.Since(start)
+
+		// nothing is looked up on the request path, take the
+		// request from the queue like the worker would
+		select {
+		case queued := <-otlRequests:
+			if queued.Email != email {
+				t.Errorf("Expected %s to be queued, but got: %s", email, queued.Email)
+			}
+		default:
+			t.Errorf("Expected %s to be queued", email)
+		}
+		return elapsed, rr
+	}
+
+	// Test case 1
+	t.Run("known and unknown addresses take the same time", func(t *testing.T) {
+		// the first response reads the page from disk
+		submit(t, "warmup@localdomain")
+
+		for i := 0; i < 3; i++ {
+			known, knownResponse := submit(t, "pwch1@localdomain")
+			unknown, unknownResponse := submit(t, "invalid@localdomain")
+
+			for _, elapsed := range []time.Duration{known, unknown} {
+				if elapsed < emailSendLatency || elapsed > emailSendLatency+50*time.Millisecond {
+					t.Errorf("Expected response after %v, but took %v", emailSendLatency, elapsed)
+				}
+			}
+			if diff := known - unknown; diff > 20*time.Millisecond || diff < -20*time.Millisecond {
+				t.Errorf("Expected similar response times, got %v for a known and %v for an unknown address", known, unknown)
+			}
+			if knownResponse.Body.String() != unknownResponse.Body.String() {
+				t.Error("Expected the same response for known and unknown addresses")
+			}
+		}
+	})
+
+	// Test case 2
+	t.Run("rate limit applies to unknown addresses", func(t *testing.T) {
+		submit(t, "invalid@localdomain")
+
+		form := url.Values{}
+		form.Add("email", "pwch1@localdomain")
+		req, _ := http.NewRequest("POST", "/emailSend", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		emailSendHandler(rr, req)
+
+		if rr.Code != http.StatusTooEarly {
+			t.Errorf("Expected status code %d, but got %d", http.StatusTooEarly, rr.Code)
+		}
+	})
+
+	emailSendLatency = 500 * time.Millisecond
+}
+
+func TestQueueOneTimeLink(t *testing.T) {
+	for i := 0; i < otlQueueSize+1; i++ {
+		queueOneTimeLink(otlRequest{Email: "pwch1@localdomain", BaseURL: defaultBaseURL()})
+	}
+	if len(otlRequests) != otlQueueSize {
+		t.Errorf("Expected %d queued requests, but got: %d", otlQueueSize, len(otlRequests))
+	}
+
+	for len(otlRequests) > 0 {
+		<-otlRequests
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultPassphraseWords     = 5
+	defaultPassphraseSeparator = "-"
+	// a suggestion failing the policy is discarded and generated again
+	maxPassphraseAttempts = 20
+)
+
+// characters appended when the policy requires a special character
+// but the separator doesn't contain one
+const passphraseSpecialChars = "!#%+=?"
+
+var passphraseWords = diceWords(effLargeWordlist)
+
+// returns a uniformly distributed random number in [0, n)
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// generates a diceware passphrase that passes the password policy
+// of the given domain
+func generatePassphrase(username, domain string) (string, error) {
+	for attempt := 0; attempt < maxPassphraseAttempts; attempt++ {
+		passphrase, err := buildPassphrase(domainConfig(domain).PasswordPolicy)
+		if err != nil {
+			return "", err
+		}
+		if valid, _ := enforcePasswordPolicy(passphrase, username, domain); valid {
+			return passphrase, nil
+		}
+	}
+	return "", errors.New("cannot generate a passphrase matching the password policy")
+}
+
+// joins random words and adds the character classes the policy requires
+func buildPassphrase(policy passwordPolicy) (string, error) {
+	cfg := loadConfig()
+	count := cfg.Passphrase.Words
+	if count <= 0 {
+		count = defaultPassphraseWords
+	}
+	separator := cfg.Passphrase.Separator
+	if separator == "" {
+		separator = defaultPassphraseSeparator
+	}
+
+	words := make([]string, 0, count)
+	for len(words) < count || len(strings.Join(words, separator)) < policy.MinLength {
+		i, err := randomInt(len(passphraseWords))
+		if err != nil {
+			return "", err
+		}
+		words = append(words, passphraseWords[i])
+	}
+
+	if policy.UpperCase {
+		i, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+	}
+
+	if policy.Digits {
+		i, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		digit, err := randomInt(10)
+		if err != nil {
+			return "", err
+		}
+		words[i] += string(rune('0' + digit))
+	}
+
+	if policy.SepcialChar && strings.IndexFunc(separator, func(char rune) bool {
+		return unicode.IsPunct(char) || unicode.IsSpace(char) || unicode.IsSymbol(char)
+	}) < 0 {
+		i, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		special, err := randomInt(len(passphraseSpecialChars))
+		if err != nil {
+			return "", err
+		}
+		words[i] += passphraseSpecialChars[special : special+1]
+	}
+
+	return strings.Join(words, separator), nil
+}
+
+type passphraseResponse struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// suggests a passphrase matching the password policy of the session's domain
+func passphraseAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, s, ok := sessionFromRequest(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	passphrase, err := generatePassphrase(s.Username, s.Domain)
+	if err != nil {
+		log.Print(err)
+		log.Print("ERROR: cannot suggest a passphrase for " + s.Domain)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, passphraseResponse{Passphrase: passphrase})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestGeneratePassphrase(t *testing.T) {
+	cfg.PasswordPolicy.MinLength = 24
+	cfg.PasswordPolicy.MaxLength = 128
+	cfg.PasswordPolicy.LowerCase = true
+	cfg.PasswordPolicy.UpperCase = true
+	cfg.PasswordPolicy.Digits = true
+	cfg.PasswordPolicy.SepcialChar = true
+	cfg.PasswordPolicy.MinScore = 3
+
+	// Test case 1
+	t.Run("passphrase passes the policy", func(t *testing.T) {
+		cfg.Passphrase.Words = 4
+		cfg.Passphrase.Separator = "-"
+
+		for i := 0; i < 10; i++ {
+			passphrase, err := generatePassphrase("alice", "example.org")
+			if err != nil {
+				t.Fatalf("Expected error to be nil, but got: %v", err)
+			}
+			if valid, message := enforcePasswordPolicy(passphrase, "alice", "example.org"); !valid {
+				t.Errorf("Expected '%s' to pass the policy, but got: %s", passphrase, message)
+			}
+			if len(strings.Split(passphrase, "-")) < 4 {
+				t.Errorf("Expected at least 4 words, but got: %s", passphrase)
+			}
+		}
+	})
+
+	// Test case 2
+	t.Run("separator without special character", func(t *testing.T) {
+		cfg.Passphrase.Words = 5
+		cfg.Passphrase.Separator = "x"
+
+		passphrase, err := generatePassphrase("alice", "example.org")
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if strings.IndexFunc(passphrase, unicode.IsPunct) < 0 && strings.IndexFunc(passphrase, unicode.IsSymbol) < 0 {
+			t.Errorf("Expected a special character in '%s'", passphrase)
+		}
+	})
+
+	// Test case 3
+	t.Run("impossible policy", func(t *testing.T) {
+		cfg.PasswordPolicy.MaxLength = 10
+
+		if _, err := generatePassphrase("alice", "example.org"); err == nil {
+			t.Error("Expected error, but got nil")
+		}
+	})
+
+	cfg.Passphrase.Words = 0
+	cfg.Passphrase.Separator = ""
+	cfg.PasswordPolicy.MinScore = 0
+}
+
+func TestPassphraseAPIHandler(t *testing.T) {
+	cfg.PasswordPolicy.MinLength = 12
+	cfg.PasswordPolicy.MaxLength = 128
+	cfg.PasswordPolicy.UpperCase = true
+	cfg.PasswordPolicy.Digits = true
+
+	req, err := http.NewRequest("POST", "/api/passphrase", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	passphraseAPIHandler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d without session, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: newTestSession(t, "alice", "example.org")})
+	rr = httptest.NewRecorder()
+	passphraseAPIHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("Expected suggestions not to be cached")
+	}
+
+	var response passphraseResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(strings.Split(response.Passphrase, defaultPassphraseSeparator)) < defaultPassphraseWords {
+		t.Errorf("Unexpected passphrase: %s", response.Passphrase)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maximum number of password bytes a scheme takes into account.
+// Everything beyond is silently ignored by the hash function.
+var passwordByteLimits = map[string]int{
+	"BLF-CRYPT": 72,
+}
+
+// message of a rule that can't be checked, e.g. because its file is unreadable
+const unverifiableRuleMessage = "This password cannot be verified right now, please try again later"
+
+// result of a single password policy rule
+type policyRule struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// evaluates every configured password policy rule
+//
+// Rules are checked against the NFKC normalized password so that
+// visually identical input is counted the same way regardless of how
+// the browser composed it. Length is measured in grapheme clusters,
+// i.e. characters as perceived by the user.
+// The password itself is hashed as entered, since dovecot compares the
+// raw bytes sent by the IMAP client.
+func evaluatePasswordPolicy(password, username, domain string) []policyRule {
+	policy := domainConfig(domain).PasswordPolicy
+	normalized := norm.NFKC.String(password)
+	length := uniseg.GraphemeClusterCount(normalized)
+
+	rules := []policyRule{
+		{
+			Name:    "min_length",
+			Passed:  length >= policy.MinLength,
+			Message: fmt.Sprintf("Please enter at least a %d character long password", policy.MinLength),
+		},
+		{
+			Name:    "max_length",
+			Passed:  length <= policy.MaxLength,
+			Message: fmt.Sprintf("Please enter at max a %d character long password", policy.MaxLength),
+		},
+	}
+
+	// pre-hashing long passwords is not an option as dovecot
+	// verifies the plain password against the stored hash
+	if limit, ok := passwordByteLimits[configuredScheme()]; ok {
+		rules = append(rules, policyRule{
+			Name:    "byte_limit",
+			Passed:  len(password) <= limit,
+			Message: fmt.Sprintf("Please enter a shorter password, at most %d bytes are supported", limit),
+		})
+	}
+
+	// a longer password fails anyway, skipping the remaining rules keeps
+	// the expensive checks bounded for arbitrarily long input
+	if length > policy.MaxLength {
+		return rules
+	}
+
+	var hasLower, hasUpper, hasNumber, hasSpecial bool
+	for _, char := range normalized {
+		switch {
+		case unicode.IsNumber(char):
+			hasNumber = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsPunct(char), unicode.IsSpace(char), unicode.IsSymbol(char):
+			hasSpecial = true
+		}
+	}
+
+	if policy.LowerCase {
+		rules = append(rules, policyRule{
+			Name:    "lower_case",
+			Passed:  hasLower,
+			Message: "Please enter at least one lower case character",
+		})
+	}
+	if policy.UpperCase {
+		rules = append(rules, policyRule{
+			Name:    "upper_case",
+			Passed:  hasUpper,
+			Message: "Please enter at least one upper case character",
+		})
+	}
+	if policy.Digits {
+		rules = append(rules, policyRule{
+			Name:    "digits",
+			Passed:  hasNumber,
+			Message: "Please enter at least one digit",
+		})
+	}
+	if policy.SepcialChar {
+		rules = append(rules, policyRule{
+			Name:    "special_char",
+			Passed:  hasSpecial,
+			Message: "Please enter at least one special character",
+		})
+	}
+
+	context := contextWords(username, domain)
+	if len(context) > 0 {
+		words := make(map[string]struct{}, len(context))
+		maxLen := 0
+		for _, word := range context {
+			words[word] = struct{}{}
+			if len([]rune(word)) > maxLen {
+				maxLen = len([]rune(word))
+			}
+		}
+		_, found := containsBlockedWord(password, words, maxLen)
+		rules = append(rules, policyRule{
+			Name:    "context_words",
+			Passed:  !found,
+			Message: "Please do not use parts of your email address or the service name",
+		})
+	}
+
+	if len(policy.Blocklist.Files) > 0 {
+		// fails closed, an unreadable blocklist must not let every password pass
+		lists, err := blockedWords(policy.Blocklist.Files)
+		if err != nil {
+			log.Print(err)
+			log.Print("ERROR: cannot check password against blocklist")
+			rules = append(rules, policyRule{
+				Name:    "blocklist",
+				Passed:  false,
+				Message: unverifiableRuleMessage,
+			})
+		} else {
+			found := false
+			for _, list := range lists {
+				if _, ok := containsBlockedWord(password, list.words, list.maxLen); ok {
+					found = true
+					break
+				}
+			}
+			rules = append(rules, policyRule{
+				Name:    "blocklist",
+				Passed:  !found,
+				Message: "Please do not use common words or names that are easy to guess",
+			})
+		}
+	}
+
+	if policy.MinScore > 0 {
+		estimate := estimatePasswordStrength(password, context...)
+		message := append([]string{"Please choose a password that is harder to guess"}, estimate.Warnings...)
+		rules = append(rules, policyRule{
+			Name:    "strength",
+			Passed:  estimate.Score >= policy.MinScore,
+			Message: strings.Join(append(message, estimate.Suggestions...), "\n"),
+		})
+	}
+
+	if index := policy.Breached.Index; index != "" {
+		threshold := policy.Breached.Threshold
+		if threshold < 1 {
+			threshold = 1
+		}
+
+		// fails closed like the blocklist
+		count, err := breachCount(index, password)
+		if err != nil {
+			log.Print(err)
+			log.Print("ERROR: cannot check password against breach index")
+			rules = append(rules, policyRule{
+				Name:    "breached",
+				Passed:  false,
+				Message: unverifiableRuleMessage,
+			})
+		} else {
+			rules = append(rules, policyRule{
+				Name:    "breached",
+				Passed:  count < threshold,
+				Message: "This password appeared in a data breach, please choose a different one",
+			})
+		}
+	}
+
+	return rules
+}
+
+// checks the password against the policy and
+// returns the messages of all failed rules at once
+func enforcePasswordPolicy(password, username, domain string) (bool, string) {
+	var failed []string
+	for _, rule := range evaluatePasswordPolicy(password, username, domain) {
+		if !rule.Passed {
+			failed = append(failed, rule.Message)
+		}
+	}
+
+	if len(failed) == 0 {
+		return true, "Success"
+	}
+	return false, strings.Join(failed, "\n")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEnforcePasswordPolicy(t *testing.T) {
+
+	cfg.PasswordPolicy.MinLength = 12
+	cfg.PasswordPolicy.MaxLength = 24
+	cfg.PasswordPolicy.LowerCase = true
+	cfg.PasswordPolicy.UpperCase = true
+	cfg.PasswordPolicy.Digits = true
+	cfg.PasswordPolicy.SepcialChar = true
+
+	testPassword := func(t testing.TB, password, expectedMessage string) {
+		t.Helper()
+
+		valid, message := enforcePasswordPolicy(password, "", "")
+
+		if valid {
+			t.Errorf("Expected invalid password for input '%s', but got valid", password)
+		}
+
+		if message != expectedMessage {
+			t.Errorf("Expected error message '%s', but got: %s", expectedMessage, message)
+		}
+
+	}
+
+	// Test case 1: Valid password that meets all requirements
+	password := "StrongPassword123!"
+	valid, message := enforcePasswordPolicy(password, "", "")
+	if !valid {
+		t.Errorf("Expected valid password for input '%s', but got invalid", password)
+	}
+	if message != "Success" {
+		t.Errorf("Expected success message for valid password, but got: %s", message)
+	}
+
+	// Test case 2
+	t.Run("password of insufficient length", func(t *testing.T) {
+		password = "Short1!"
+		expectedMessage := fmt.Sprintf("Please enter at least a %d character long password", cfg.PasswordPolicy.MinLength)
+		testPassword(t, password, expectedMessage)
+	})
+
+	// Test case 3
+	t.Run("password exceeding maximum length", func(t *testing.T) {
+		password = "ThisPasswordExceedsTheMaximumAllowedLength1!"
+		expectedMessage := fmt.Sprintf("Please enter at max a %d character long password", cfg.PasswordPolicy.MaxLength)
+		testPassword(t, password, expectedMessage)
+	})
+
+	// Test case 4
+	t.Run("password missing lower case character", func(t *testing.T) {
+		password = "PASSWORD123!"
+		expectedMessage := "Please enter at least one lower case character"
+		testPassword(t, password, expectedMessage)
+	})
+
+	// Test case 5
+	t.Run("password missing upper case character", func(t *testing.T) {
+		password = "password123!"
+		expectedMessage := "Please enter at least one upper case character"
+		testPassword(t, password, expectedMessage)
+	})
+
+	// Test case 6
+	t.Run("password missing digit", func(t *testing.T) {
+		password = "PasswordWithoutDigit!"
+		expectedMessage := "Please enter at least one digit"
+		testPassword(t, password, expectedMessage)
+	})
+
+	// Test case 7
+	t.Run("password missing special character", func(t *testing.T) {
+		password = "PasswordNoSpecial123"
+		expectedMessage := "Please enter at least one special character"
+		testPassword(t, password, expectedMessage)
+	})
+
+	// Test case 8
+	t.Run("report all failed rules at once", func(t *testing.T) {
+		password = "short"
+		expectedMessage := fmt.Sprintf("Please enter at least a %d character long password\n", cfg.PasswordPolicy.MinLength) +
+			"Please enter at least one upper case character\n" +
+			"Please enter at least one digit\n" +
+			"Please enter at least one special character"
+		testPassword(t, password, expectedMessage)
+	})
+
+	// Test case 9
+	t.Run("count characters instead of bytes", func(t *testing.T) {
+		// combining diaeresis without precomposed form, 24 characters but 45 runes
+		password = "Q\u0308" + strings.Repeat("q\u0308", 20) + "x1!"
+		valid, message := enforcePasswordPolicy(password, "", "")
+		if !valid {
+			t.Errorf("Expected valid password for input '%s', but got: %s", password, message)
+		}
+	})
+
+	// Test case 10
+	t.Run("password exceeding bcrypt byte limit", func(t *testing.T) {
+		cfg.Hash.Scheme = "BLF-CRYPT"
+		cfg.PasswordPolicy.MaxLength = 128
+
+		password = strings.Repeat("Ää1!", 19)
+		expectedMessage := "Please enter a shorter password, at most 72 bytes are supported"
+		testPassword(t, password, expectedMessage)
+
+		cfg.Hash.Scheme = ""
+		cfg.PasswordPolicy.MaxLength = 24
+	})
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// entry of trusted_proxies matching connections over the unix socket
+const trustedUnixSocket = "unix"
+
+// values of forwarded_header, the header family the trusted proxies set
+const (
+	forwardedHeaderXForwarded = "x-forwarded-for"
+	forwardedHeaderRFC7239    = "forwarded"
+)
+
+// trusted_proxies and forwarded_header parsed by parseTrustedProxies
+type trustedProxies struct {
+	unix     bool
+	prefixes []netip.Prefix
+	// read Forwarded instead of X-Forwarded-For and X-Forwarded-Proto
+	rfc7239 bool
+}
+
+// parses the CIDRs, addresses and "unix" listed in trusted_proxies
+// and which forwarding headers they set
+func parseTrustedProxies(cfg *config) error {
+	cfg.trustedProxies = trustedProxies{}
+	switch strings.ToLower(cfg.ForwardedHeader) {
+	case "", forwardedHeaderXForwarded:
+	case forwardedHeaderRFC7239:
+		cfg.trustedProxies.rfc7239 = true
+	default:
+		return fmt.Errorf("forwarded_header: must be %s or %s", forwardedHeaderXForwarded, forwardedHeaderRFC7239)
+	}
+	for _, entry := range cfg.TrustedProxies {
+		if entry == trustedUnixSocket {
+			cfg.trustedProxies.unix = true
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			cfg.trustedProxies.prefixes = append(cfg.trustedProxies.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return fmt.Errorf("trusted_proxies: invalid entry %s", entry)
+		}
+		cfg.trustedProxies.prefixes = append(cfg.trustedProxies.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return nil
+}
+
+// reports whether the peer, as returned by peerAddress, is a trusted proxy
+func (p trustedProxies) contains(peer string) bool {
+	if peer == trustedUnixSocket {
+		return p.unix
+	}
+	addr, err := netip.ParseAddr(peer)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the address of the direct peer, or "unix"
+// for connections over the unix socket
+func peerAddress(r *http.Request) string {
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return trustedUnixSocket
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// returns the address of the client and the scheme it used
+//
+// Forwarding headers are only read when the direct peer is a trusted
+// proxy, and only the family configured in forwarded_header, the other
+// one may have been sent by the client and passed on untouched. The
+// hops are walked from the closest to the farthest and the first hop
+// not added by a trusted proxy is the client, so addresses the client
+// made up itself are ignored.
+func forwardedClient(r *http.Request) (string, string) {
+	cfg := requestConfig(r)
+	client, scheme := peerAddress(r), "https"
+	if !cfg.trustedProxies.contains(client) {
+		return client, scheme
+	}
+
+	var hops []string
+	var protos []string
+	if cfg.trustedProxies.rfc7239 {
+		hops, protos = parseForwarded(r.Header.Values("Forwarded"))
+	} else {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		for _, value := range r.Header.Values("X-Forwarded-Proto") {
+			for _, proto := range strings.Split(value, ",") {
+				protos = append(protos, strings.TrimSpace(proto))
+			}
+		}
+	}
+
+	// the scheme is only taken from the closest proxy
+	if len(protos) > 0 {
+		if proto := strings.ToLower(protos[len(protos)-1]); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == "" {
+			break
+		}
+		client = hops[i]
+		if !cfg.trustedProxies.contains(client) {
+			break
+		}
+	}
+	return client, scheme
+}
+
+// returns the for and proto parameters of every hop listed in RFC 7239
+// Forwarded headers, addresses are stripped of brackets and ports
+func parseForwarded(values []string) ([]string, []string) {
+	var hops, protos []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					hop = forwardedNode(val)
+				case "proto":
+					protos = append(protos, val)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops, protos
+}
+
+// strips the port from a node like "192.0.2.1:4711" or "[2001:db8::1]:4711"
+func forwardedNode(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
+
+// returns the address of the client for logging and rate limiting
+func clientIP(r *http.Request) string {
+	client, _ := forwardedClient(r)
+	return client
+}
+
+// returns the URL pwch is reached at by the client, the host is always
+// the configured domain as the Host header is controlled by the client
+func baseURL(r *http.Request) string {
+	cfg := requestConfig(r)
+	_, scheme := forwardedClient(r)
+	return scheme + "://" + cfg.Domain + cfg.URLPrefix
+}
+
+// used for mails that aren't sent in response to a request
+func defaultBaseURL() string {
+	cfg := loadConfig()
+	return "https://" + cfg.Domain + cfg.URLPrefix
+}
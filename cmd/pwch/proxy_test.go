@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	// Test case 1
+	t.Run("valid entries", func(t *testing.T) {
+		c := &config{TrustedProxies: []string{"unix", "10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}}
+		if err := parseTrustedProxies(c); err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+
+		for peer, expected := range map[string]bool{
+			"unix":            true,
+			"10.1.2.3":        true,
+			"192.0.2.1":       true,
+			"192.0.2.2":       false,
+			"2001:db8::1":     true,
+			"::ffff:10.0.0.1": true,
+			"unknown":         false,
+		} {
+			if c.trustedProxies.contains(peer) != expected {
+				t.Errorf("Expected %s to be trusted: %v", peer, expected)
+			}
+		}
+	})
+
+	// Test case 2
+	t.Run("invalid entry", func(t *testing.T) {
+		c := &config{TrustedProxies: []string{"proxy.example"}}
+		if err := parseTrustedProxies(c); err == nil {
+			t.Error("Expected error for hostname")
+		}
+	})
+
+	// Test case 3
+	t.Run("forwarded header", func(t *testing.T) {
+		for header, expected := range map[string]bool{"": false, "X-Forwarded-For": false, "Forwarded": true} {
+			c := &config{ForwardedHeader: header}
+			if err := parseTrustedProxies(c); err != nil || c.trustedProxies.rfc7239 != expected {
+				t.Errorf("Unexpected result for %q: %v (%v)", header, c.trustedProxies.rfc7239, err)
+			}
+		}
+
+		c := &config{ForwardedHeader: "X-Real-IP"}
+		if err := parseTrustedProxies(c); err == nil {
+			t.Error("Expected error for unsupported header")
+		}
+	})
+}
+
+func TestForwardedClient(t *testing.T) {
+	cfg.Domain = "example.com"
+	cfg.URLPrefix = "/pwch"
+	cfg.TrustedProxies = []string{"unix", "10.0.0.0/8"}
+	if err := parseTrustedProxies(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t testing.TB, remoteAddr string, header http.Header, expectedClient, expectedScheme string) {
+		t.Helper()
+
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = remoteAddr
+		req.Header = header
+
+		client, scheme := forwardedClient(req)
+		if client != expectedClient || scheme != expectedScheme {
+			t.Errorf("Expected %s over %s, but got: %s over %s", expectedClient, expectedScheme, client, scheme)
+		}
+	}
+
+	// Test case 1
+	t.Run("untrusted peer", func(t *testing.T) {
+		header := http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"http"}}
+		check(t, "203.0.113.5:4711", header, "203.0.113.5", "https")
+	})
+
+	// Test case 2
+	t.Run("X-Forwarded-For over unix socket", func(t *testing.T) {
+		header := http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"http"}}
+		check(t, "@", header, "198.51.100.1", "http")
+	})
+
+	// Test case 3
+	t.Run("spoofed hops are skipped", func(t *testing.T) {
+		header := http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1, 10.0.0.2"}}
+		check(t, "10.0.0.1:4711", header, "198.51.100.1", "https")
+	})
+
+	// Test case 4
+	t.Run("Forwarded sent by the client is ignored", func(t *testing.T) {
+		header := http.Header{
+			"Forwarded":       {"for=6.6.6.6;proto=http"},
+			"X-Forwarded-For": {"6.6.6.6, 203.0.113.7"},
+		}
+		check(t, "@", header, "203.0.113.7", "https")
+	})
+
+	// Test case 5
+	t.Run("RFC 7239 Forwarded", func(t *testing.T) {
+		cfg.ForwardedHeader = "forwarded"
+		if err := parseTrustedProxies(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			cfg.ForwardedHeader = ""
+			parseTrustedProxies(&cfg)
+		}()
+
+		header := http.Header{
+			"Forwarded":         {`for="[2001:db8::1]:4711";proto=http, for=10.0.0.2;proto=https`},
+			"X-Forwarded-For":   {"1.2.3.4"},
+			"X-Forwarded-Proto": {"http"},
+		}
+		check(t, "@", header, "2001:db8::1", "https")
+	})
+
+	// Test case 6
+	t.Run("unknown proto", func(t *testing.T) {
+		header := http.Header{"X-Forwarded-Proto": {"gopher"}}
+		check(t, "@", header, "unix", "https")
+	})
+
+	// Test case 7
+	t.Run("base URL uses configured domain", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Host = "evil.example"
+		req.Header.Set("X-Forwarded-Proto", "http")
+
+		if url := baseURL(req); url != "http://example.com/pwch" {
+			t.Errorf("Expected base URL http://example.com/pwch, but got: %s", url)
+		}
+	})
+
+	cfg.TrustedProxies = nil
+	cfg.trustedProxies = trustedProxies{}
+	cfg.URLPrefix = ""
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// config swapped in by the last reload
+//
+// A reload never modifies a config in use, it replaces the pointer.
+// Requests take a snapshot when they start and keep using it, so a
+// reload neither waits for running requests nor blocks new ones.
+// Until the first reload cfg as read on start is used.
+var currentConfig atomic.Pointer[config]
+
+type configKey struct{}
+
+// returns the current config, code that isn't tied to a request
+// loads it once and keeps the pointer for the rest of its work
+func loadConfig() *config {
+	if c := currentConfig.Load(); c != nil {
+		return c
+	}
+	return &cfg
+}
+
+// returns the config snapshot taken when the request started
+func requestConfig(r *http.Request) *config {
+	if c, ok := r.Context().Value(configKey{}).(*config); ok {
+		return c
+	}
+	return loadConfig()
+}
+
+// takes a config snapshot for every request
+func withConfigSnapshot(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), configKey{}, loadConfig())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// reads and validates the config file and swaps it in, the running
+// config stays untouched if the new one is invalid
+//
+// Listener settings and url_prefix are kept, they are only
+// applied on start.
+func reloadConfig() error {
+	newCfg := &config{}
+	if err := readFile(newCfg); err != nil {
+		return err
+	}
+
+	running := loadConfig()
+	if newCfg.Server != running.Server || newCfg.URLPrefix != running.URLPrefix {
+		log.Print("INFO: Changes to server and url_prefix require a restart")
+		newCfg.Server = running.Server
+		newCfg.URLPrefix = running.URLPrefix
+	}
+	currentConfig.Store(newCfg)
+	return nil
+}
+
+// reloads the config and the TLS certificate, if any, on every signal
+// sent to the channel
+func reloadOnSignal(reloader *certificateReloader, signals <-chan os.Signal) {
+	for range signals {
+		_ = sdNotify("RELOADING=1")
+
+		if err := reloadConfig(); err != nil {
+			log.Print(err)
+			log.Print("ERROR: Reloading config failed, keeping the current one")
+		} else {
+			log.Print("INFO: Reloaded config from " + configPath)
+		}
+
+		if reloader != nil {
+			if err := reloader.reload(); err != nil {
+				log.Print(err)
+				log.Print("ERROR: Reloading TLS certificate failed, keeping the previous one")
+			} else {
+				log.Print("INFO: Reloaded TLS certificate")
+			}
+		}
+
+		_ = sdNotify("READY=1")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	oldCfg, oldPath := cfg, configPath
+
+	original, err := os.ReadFile("../../config/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath = filepath.Join(t.TempDir(), "config.yml")
+
+	writeConfig := func(t testing.TB, replacements ...string) {
+		t.Helper()
+
+		content := strings.NewReplacer(replacements...).Replace(string(original))
+		if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig(t)
+	if err := readFile(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case 1
+	t.Run("changed policy is applied", func(t *testing.T) {
+		writeConfig(t, "min_length: 12", "min_length: 16")
+
+		if err := reloadConfig(); err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if loadConfig().PasswordPolicy.MinLength != 16 {
+			t.Errorf("Expected min_length 16, but got: %d", loadConfig().PasswordPolicy.MinLength)
+		}
+	})
+
+	// Test case 2
+	t.Run("invalid config is rejected", func(t *testing.T) {
+		writeConfig(t, "min_length: 12", "min_length: 20", "min_score: 3", "min_score: 9")
+
+		if err := reloadConfig(); err == nil {
+			t.Error("Expected invalid config to be rejected")
+		}
+		if loadConfig().PasswordPolicy.MinLength != 16 || loadConfig().PasswordPolicy.MinScore != 3 {
+			t.Error("Expected the running config to be kept")
+		}
+	})
+
+	// Test case 3
+	t.Run("listener and url_prefix need a restart", func(t *testing.T) {
+		writeConfig(t, "url_prefix: /selfservice", "url_prefix: /other", "pwch.sock", "other.sock")
+
+		if err := reloadConfig(); err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		if loadConfig().URLPrefix != "/selfservice" || !strings.HasSuffix(loadConfig().Server.Listen, "pwch.sock") {
+			t.Errorf("Expected url_prefix and listener to be kept, got: %s %s", loadConfig().URLPrefix, loadConfig().Server.Listen)
+		}
+	})
+
+	// Test case 4
+	t.Run("requests keep their snapshot", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		minLength := make(chan int)
+		handler := withConfigSnapshot(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			minLength <- requestConfig(r).PasswordPolicy.MinLength
+		}))
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		<-started
+
+		writeConfig(t, "min_length: 12", "min_length: 18")
+		if err := reloadConfig(); err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+		close(release)
+
+		if got := <-minLength; got != 12 {
+			t.Errorf("Expected the request to keep min_length 12, but got: %d", got)
+		}
+		if loadConfig().PasswordPolicy.MinLength != 18 {
+			t.Errorf("Expected min_length 18 for new requests, but got: %d", loadConfig().PasswordPolicy.MinLength)
+		}
+	})
+
+	currentConfig.Store(nil)
+	cfg, configPath = oldCfg, oldPath
+}
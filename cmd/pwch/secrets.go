@@ -0,0 +1,122 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// matches ${NAME} references to environment variables
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// a config value holding a secret, which can be given inline,
+// read from a file or from a systemd credential
+type secretSource struct {
+	name       string
+	value      *string
+	file       string
+	credential string
+}
+
+func secretSources(cfg *config) []secretSource {
+	return []secretSource{
+		{"db.password", &cfg.DB.Password, cfg.DB.PasswordFile, cfg.DB.PasswordCredential},
+		{"smtp.login_password", &cfg.SMTP.LoginPassword, cfg.SMTP.LoginPasswordFile, cfg.SMTP.LoginPasswordCredential},
+	}
+}
+
+// replaces every secret with its value from the environment, the file
+// or the credential and refuses inline secrets in a config file others
+// can read
+func resolveSecrets(cfg *config, info os.FileInfo) error {
+	for _, source := range secretSources(cfg) {
+		value, inline, err := resolveSecret(source)
+		if err != nil {
+			return err
+		}
+		if inline && info != nil && info.Mode().Perm()&0o004 != 0 {
+			return fmt.Errorf("%s contains %s but is readable by everyone, run chmod o-r %s or move the secret to a file, credential or environment variable",
+				info.Name(), source.name, info.Name())
+		}
+		*source.value = value
+	}
+	return nil
+}
+
+// returns the secret and whether it was written into the config file
+func resolveSecret(source secretSource) (string, bool, error) {
+	set := 0
+	for _, v := range []string{*source.value, source.file, source.credential} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", false, fmt.Errorf("%s: only one of the value, _file and _credential can be set", source.name)
+	}
+
+	switch {
+	case source.file != "":
+		secret, err := readSecretFile(source.file)
+		return secret, false, err
+	case source.credential != "":
+		secret, err := readCredential(source.credential)
+		return secret, false, err
+	}
+
+	value := *source.value
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReference.FindStringSubmatch(reference)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return env
+	})
+	if len(missing) > 0 {
+		return "", false, fmt.Errorf("%s: environment variable %s is not set", source.name, strings.Join(missing, ", "))
+	}
+	inline := envReference.ReplaceAllString(value, "") != ""
+	return expanded, inline, nil
+}
+
+// reads a secret, a trailing newline is not part of it
+func readSecretFile(path string) (string, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
+// reads a credential passed with LoadCredential= or SetCredential=
+// in the systemd unit, see systemd.exec(5)
+func readCredential(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", errors.New("credential " + name + " requested but CREDENTIALS_DIRECTORY is not set")
+	}
+	if name != filepath.Base(name) {
+		return "", errors.New("invalid credential name " + name)
+	}
+	return readSecretFile(filepath.Join(dir, name))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db_password"), []byte("from_file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	t.Setenv("PWCH_DB_PASSWORD", "from_env")
+
+	worldReadable := fakeFileInfo{name: "config.yml", mode: 0644}
+	private := fakeFileInfo{name: "config.yml", mode: 0640}
+
+	resolve := func(t testing.TB, c *config, info os.FileInfo) error {
+		t.Helper()
+		return resolveSecrets(c, info)
+	}
+
+	// Test case 1
+	t.Run("environment", func(t *testing.T) {
+		c := &config{}
+		c.DB.Password = "${PWCH_DB_PASSWORD}"
+		if err := resolve(t, c, worldReadable); err != nil || c.DB.Password != "from_env" {
+			t.Errorf("Expected password from environment, got: %s, %v", c.DB.Password, err)
+		}
+	})
+
+	// Test case 2
+	t.Run("file", func(t *testing.T) {
+		c := &config{}
+		c.DB.PasswordFile = filepath.Join(dir, "db_password")
+		if err := resolve(t, c, worldReadable); err != nil || c.DB.Password != "from_file" {
+			t.Errorf("Expected password from file, got: %s, %v", c.DB.Password, err)
+		}
+	})
+
+	// Test case 3
+	t.Run("systemd credential", func(t *testing.T) {
+		c := &config{}
+		c.SMTP.LoginPasswordCredential = "db_password"
+		if err := resolve(t, c, worldReadable); err != nil || c.SMTP.LoginPassword != "from_file" {
+			t.Errorf("Expected password from credential, got: %s, %v", c.SMTP.LoginPassword, err)
+		}
+	})
+
+	// Test case 4
+	t.Run("inline secret in world-readable config", func(t *testing.T) {
+		c := &config{}
+		c.SMTP.LoginPassword = "inline"
+		if err := resolve(t, c, worldReadable); err == nil || !strings.Contains(err.Error(), "smtp.login_password") {
+			t.Errorf("Expected inline secret to be refused, got: %v", err)
+		}
+
+		c.SMTP.LoginPassword = "inline"
+		if err := resolve(t, c, private); err != nil || c.SMTP.LoginPassword != "inline" {
+			t.Errorf("Expected inline secret in private config to be accepted, got: %v", err)
+		}
+	})
+
+	// Test case 5
+	t.Run("errors", func(t *testing.T) {
+		c := &config{}
+		c.DB.Password = "${PWCH_UNSET_PASSWORD}"
+		if err := resolve(t, c, private); err == nil {
+			t.Error("Expected unset environment variable to be an error")
+		}
+
+		c = &config{}
+		c.DB.Password = "inline"
+		c.DB.PasswordFile = filepath.Join(dir, "db_password")
+		if err := resolve(t, c, private); err == nil {
+			t.Error("Expected more than one source to be an error")
+		}
+
+		c = &config{}
+		c.DB.PasswordCredential = "../db_password"
+		if err := resolve(t, c, private); err == nil {
+			t.Error("Expected credential outside the credentials directory to be an error")
+		}
+	})
+}
+
+// file info with a fixed name and mode
+type fakeFileInfo struct {
+	os.FileInfo
+	name string
+	mode os.FileMode
+}
+
+func (f fakeFileInfo) Name() string      { return f.name }
+func (f fakeFileInfo) Mode() os.FileMode { return f.mode }
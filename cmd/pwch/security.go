@@ -0,0 +1,95 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// placeholder in the configured Content-Security-Policy that is
+// replaced with a fresh nonce for every response
+const cspNoncePlaceholder = "{nonce}"
+
+type securityHeaders struct {
+	ContentSecurityPolicy   string `yaml:"content_security_policy"`
+	ReferrerPolicy          string `yaml:"referrer_policy"`
+	CacheControl            string `yaml:"cache_control"`
+	StrictTransportSecurity string `yaml:"strict_transport_security"`
+}
+
+// used for every header not set in the config file,
+// an empty value in the config file disables the header
+var defaultSecurityHeaders = securityHeaders{
+	ContentSecurityPolicy: "default-src 'none'; script-src 'self' 'nonce-" + cspNoncePlaceholder + "'; " +
+		"style-src 'self'; img-src 'self'; connect-src 'self'; form-action 'self'; " +
+		"frame-ancestors 'none'; base-uri 'none'",
+	ReferrerPolicy:          "no-referrer",
+	CacheControl:            "no-store",
+	StrictTransportSecurity: "max-age=63072000; includeSubDomains",
+}
+
+type cspNonceKey struct{}
+
+// sets the configured security headers on every response
+//
+// The Strict-Transport-Security header is only sent on TLS connections,
+// browsers ignore it on plain HTTP anyway.
+func withSecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := requestConfig(r)
+		headers := cfg.SecurityHeaders
+		header := w.Header()
+
+		if csp := headers.ContentSecurityPolicy; csp != "" {
+			if strings.Contains(csp, cspNoncePlaceholder) {
+				nonce, err := genRandomBytes(18)
+				if err != nil {
+					log.Print(err)
+					log.Print("ERROR: cannot generate CSP nonce")
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				encoded := base64.StdEncoding.EncodeToString(nonce)
+				csp = strings.ReplaceAll(csp, cspNoncePlaceholder, encoded)
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, encoded))
+			}
+			header.Set("Content-Security-Policy", csp)
+		}
+		if headers.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", headers.ReferrerPolicy)
+		}
+		if headers.CacheControl != "" {
+			header.Set("Cache-Control", headers.CacheControl)
+		}
+		if headers.StrictTransportSecurity != "" && r.TLS != nil {
+			header.Set("Strict-Transport-Security", headers.StrictTransportSecurity)
+		}
+		header.Set("X-Content-Type-Options", "nosniff")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// returns the CSP nonce of the response, templates add it
+// to inline scripts so they are allowed to run
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
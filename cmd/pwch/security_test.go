@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithSecurityHeaders(t *testing.T) {
+	cfg.SecurityHeaders = defaultSecurityHeaders
+
+	var nonce string
+	handler := withSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = cspNonce(r)
+	}))
+
+	serve := func(t testing.TB, req *http.Request) http.Header {
+		t.Helper()
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Header()
+	}
+
+	// Test case 1
+	t.Run("default headers", func(t *testing.T) {
+		header := serve(t, httptest.NewRequest("GET", "/changePassword", nil))
+
+		expected := map[string]string{
+			"Referrer-Policy":        "no-referrer",
+			"Cache-Control":          "no-store",
+			"X-Content-Type-Options": "nosniff",
+		}
+		for name, value := range expected {
+			if got := header.Get(name); got != value {
+				t.Errorf("Expected %s to be %q, but got: %q", name, value, got)
+			}
+		}
+		if !strings.Contains(header.Get("Content-Security-Policy"), "frame-ancestors 'none'") {
+			t.Errorf("Expected CSP to deny framing, got: %s", header.Get("Content-Security-Policy"))
+		}
+		if header.Get("Strict-Transport-Security") != "" {
+			t.Error("Expected no HSTS header without TLS")
+		}
+	})
+
+	// Test case 2
+	t.Run("fresh nonce per response", func(t *testing.T) {
+		header := serve(t, httptest.NewRequest("GET", "/changePassword", nil))
+		first := nonce
+		if first == "" || !strings.Contains(header.Get("Content-Security-Policy"), "'nonce-"+first+"'") {
+			t.Errorf("Expected CSP to contain the nonce %q, got: %s", first, header.Get("Content-Security-Policy"))
+		}
+
+		serve(t, httptest.NewRequest("GET", "/changePassword", nil))
+		if nonce == first {
+			t.Error("Expected a new nonce for every response")
+		}
+	})
+
+	// Test case 3
+	t.Run("HSTS over TLS", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/changePassword", nil)
+		req.TLS = &tls.ConnectionState{}
+
+		header := serve(t, req)
+		if header.Get("Strict-Transport-Security") != defaultSecurityHeaders.StrictTransportSecurity {
+			t.Errorf("Expected HSTS header, got: %q", header.Get("Strict-Transport-Security"))
+		}
+	})
+
+	// Test case 4
+	t.Run("configured values", func(t *testing.T) {
+		cfg.SecurityHeaders = securityHeaders{
+			ContentSecurityPolicy: "default-src 'self'",
+			ReferrerPolicy:        "same-origin",
+		}
+
+		header := serve(t, httptest.NewRequest("GET", "/changePassword", nil))
+		if header.Get("Content-Security-Policy") != "default-src 'self'" || header.Get("Referrer-Policy") != "same-origin" {
+			t.Errorf("Expected configured values, got: %v", header)
+		}
+		if header.Get("Cache-Control") != "" {
+			t.Error("Expected empty value to disable the header")
+		}
+		if nonce != "" {
+			t.Error("Expected no nonce without placeholder")
+		}
+	})
+
+	cfg.SecurityHeaders = securityHeaders{}
+}
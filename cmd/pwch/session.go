@@ -0,0 +1,183 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The one time link only carries a random token. Opening it shows a
+// Continue button that posts the token, which is swapped for a session
+// cookie and a redirect to a clean URL, so neither the account nor the
+// session show up in access logs, browser history or Referer headers of
+// later requests. The token is consumed by the swap, posting it again fails.
+const (
+	sessionCookieName = "pwch_session"
+	sessionLifetime   = 15 * time.Minute
+)
+
+// account a one time link was sent to
+type oneTimeLink struct {
+	Username string
+	Domain   string
+	Expires  time.Time
+}
+
+// server side state of a password change session
+type session struct {
+	Username  string
+	Domain    string
+	CSRFToken string
+	Expires   time.Time
+}
+
+// this is where password change sessions are stored
+//
+// key   = random session id stored in the cookie
+// value = session
+//
+// entries are deleted after a successful password
+// change or when the session expires
+var sessions = struct {
+	sync.Mutex
+	m map[string]*session
+}{m: make(map[string]*session)}
+
+// returns the one time link of token unless it expired
+func lookupOneTimeLink(token string) (oneTimeLink, bool) {
+	oneTimeURLs.RLock()
+	link, ok := oneTimeURLs.m[token]
+	oneTimeURLs.RUnlock()
+
+	return link, ok && time.Now().Before(link.Expires)
+}
+
+// removes the one time link of token and returns it unless it expired,
+// a link can be consumed only once
+func consumeOneTimeLink(token string) (oneTimeLink, bool) {
+	oneTimeURLs.Lock()
+	link, ok := oneTimeURLs.m[token]
+	delete(oneTimeURLs.m, token)
+	oneTimeURLs.Unlock()
+
+	return link, ok && time.Now().Before(link.Expires)
+}
+
+func deleteSession(id string) {
+	sessions.Lock()
+	delete(sessions.m, id)
+	sessions.Unlock()
+}
+
+// deletes expired one time links and sessions
+func expireOneTimeLinks() {
+	now := time.Now()
+
+	var expired []string
+	oneTimeURLs.RLock()
+	for token, link := range oneTimeURLs.m {
+		if now.After(link.Expires) {
+			expired = append(expired, token)
+			log.Print("INFO: Deleted expired one time link for " + link.Username + "@" + link.Domain)
+		}
+	}
+	oneTimeURLs.RUnlock()
+
+	for _, token := range expired {
+		deleteFromHashMap(oneTimeURLs.m, token)
+	}
+
+	sessions.Lock()
+	for id, s := range sessions.m {
+		if now.After(s.Expires) {
+			delete(sessions.m, id)
+		}
+	}
+	sessions.Unlock()
+}
+
+// creates a session for the one time link, it expires
+// after sessionLifetime but never outlives the link
+func createSession(link oneTimeLink) (string, time.Time, error) {
+	id, err := genRandomString(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expires := time.Now().Add(sessionLifetime)
+	if link.Expires.Before(expires) {
+		expires = link.Expires
+	}
+
+	sessions.Lock()
+	sessions.m[id] = &session{
+		Username: link.Username,
+		Domain:   link.Domain,
+		Expires:  expires,
+	}
+	sessions.Unlock()
+	return id, expires, nil
+}
+
+// returns the id and a copy of the session of the request's cookie,
+// ok is false if the session expired
+func sessionFromRequest(r *http.Request) (id string, s session, ok bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", session{}, false
+	}
+
+	sessions.Lock()
+	stored, found := sessions.m[cookie.Value]
+	if found {
+		s = *stored
+	}
+	sessions.Unlock()
+
+	if !found || time.Now().After(s.Expires) {
+		return "", session{}, false
+	}
+	return cookie.Value, s, true
+}
+
+func setSessionCookie(w http.ResponseWriter, id string, expires time.Time) {
+	cfg := loadConfig()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     cfg.URLPrefix + "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	cfg := loadConfig()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     cfg.URLPrefix + "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// returns the id of a session for the account as created by opening a one time link
+func newTestSession(t testing.TB, username, domain string) string {
+	t.Helper()
+
+	link := oneTimeLink{Username: username, Domain: domain, Expires: time.Now().Add(time.Minute)}
+	id, _, err := createSession(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestStartSession(t *testing.T) {
+	cfg.AssetsPath = "../../assets/html"
+	cfg.URLPrefix = ""
+
+	token, _ := genRandomString(64)
+	addToHashMap(oneTimeURLs.m, token, oneTimeLink{Username: "pwch1", Domain: "localdomain", Expires: time.Now().Add(time.Minute)})
+
+	postToken := func(t testing.TB) *httptest.ResponseRecorder {
+		t.Helper()
+
+		form := url.Values{}
+		form.Add("token", token)
+		req, err := http.NewRequest("POST", "/changePassword", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		passwordChangeHandler(rr, req)
+		return rr
+	}
+
+	// Test case 1
+	t.Run("opening the link keeps the token", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", "/changePassword?token="+token, nil)
+			rr := httptest.NewRecorder()
+			passwordChangeHandler(rr, req)
+
+			if !strings.Contains(rr.Body.String(), `<input type="hidden" name="token" value="`+token+`">`) {
+				t.Errorf("Expected landing page posting the token, got: %s", rr.Body.String())
+			}
+			if len(rr.Result().Cookies()) != 0 || rr.Header().Get("Referrer-Policy") != "no-referrer" {
+				t.Errorf("Expected no session and Referrer-Policy no-referrer, got: %v", rr.Header())
+			}
+		}
+		if _, ok := lookupOneTimeLink(token); !ok {
+			t.Error("Expected token to be kept")
+		}
+	})
+
+	rr := postToken(t)
+
+	// Test case 2
+	t.Run("redirect to clean URL", func(t *testing.T) {
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/changePassword" {
+			t.Errorf("Expected redirect to the clean URL, got %d %s", rr.Code, rr.Header().Get("Location"))
+		}
+	})
+
+	// Test case 3
+	t.Run("session cookie", func(t *testing.T) {
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("Expected one cookie, got %d", len(cookies))
+		}
+		cookie := cookies[0]
+		if cookie.Name != sessionCookieName || !cookie.HttpOnly || !cookie.Secure ||
+			cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge <= 0 {
+			t.Errorf("Unexpected session cookie: %+v", cookie)
+		}
+
+		req, _ := http.NewRequest("GET", "/changePassword", nil)
+		req.AddCookie(cookie)
+		_, s, ok := sessionFromRequest(req)
+		if !ok || s.Username != "pwch1" || s.Domain != "localdomain" {
+			t.Errorf("Expected session of pwch1@localdomain, got: %+v", s)
+		}
+	})
+
+	// Test case 4
+	t.Run("token can be used only once", func(t *testing.T) {
+		rr := postToken(t)
+		if rr.Body.String() != "Link expired" || len(rr.Result().Cookies()) != 0 {
+			t.Errorf("Expected second use of the token to fail, got: %s", rr.Body.String())
+		}
+
+		req, _ := http.NewRequest("GET", "/changePassword?token="+token, nil)
+		rr = httptest.NewRecorder()
+		passwordChangeHandler(rr, req)
+		if rr.Body.String() != "Link expired" {
+			t.Errorf("Expected used link to be expired, got: %s", rr.Body.String())
+		}
+	})
+
+	// Test case 5
+	t.Run("session outlives the consumed link", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/changePassword", nil)
+		req.AddCookie(rr.Result().Cookies()[0])
+
+		id, _, ok := sessionFromRequest(req)
+		if !ok {
+			t.Fatal("Expected session to stay valid after the link was consumed")
+		}
+		deleteSession(id)
+		if _, _, ok := sessionFromRequest(req); ok {
+			t.Error("Expected deleted session to be invalid")
+		}
+	})
+}
+
+func TestExpireOneTimeLinks(t *testing.T) {
+	token, _ := genRandomString(64)
+	link := oneTimeLink{Username: "pwch1", Domain: "localdomain", Expires: time.Now().Add(time.Minute)}
+	addToHashMap(oneTimeURLs.m, token, link)
+	id, _, _ := createSession(link)
+
+	// Test case 1
+	t.Run("valid link and session are kept", func(t *testing.T) {
+		expireOneTimeLinks()
+		if _, ok := oneTimeURLs.m[token]; !ok {
+			t.Error("Expected valid link to be kept")
+		}
+		if _, ok := sessions.m[id]; !ok {
+			t.Error("Expected valid session to be kept")
+		}
+	})
+
+	// Test case 2
+	t.Run("expired link and session are deleted", func(t *testing.T) {
+		link.Expires = time.Now().Add(-time.Second)
+		addToHashMap(oneTimeURLs.m, token, link)
+		sessions.m[id].Expires = link.Expires
+
+		expireOneTimeLinks()
+		if _, ok := oneTimeURLs.m[token]; ok {
+			t.Error("Expected expired link to be deleted")
+		}
+		if _, ok := sessions.m[id]; ok {
+			t.Error("Expected expired session to be deleted")
+		}
+	})
+}
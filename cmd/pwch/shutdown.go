@@ -0,0 +1,61 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// time to finish requests, password changes and mails after
+// SIGTERM, stays below the default TimeoutStopSec of systemd
+const shutdownTimeout = 30 * time.Second
+
+// password changes and mails in progress, the process doesn't exit
+// before they finished so no change is left half done
+var inFlight sync.WaitGroup
+
+// stops accepting connections, waits for running requests, then lets
+// the one time link worker send what's queued and waits for all work
+// in flight, everything within shutdownTimeout
+func shutdown(server *http.Server, stopWorker context.CancelFunc) {
+	log.Print("INFO: Shutting down")
+	_ = sdNotify("STOPPING=1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Print(err)
+		log.Print("ERROR: Requests still running at shutdown")
+	}
+	stopWorker()
+
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Print("INFO: Shutdown complete")
+	case <-ctx.Done():
+		log.Print("ERROR: Password changes or mails still running at shutdown")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	// discard log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	go server.Serve(listener)
+
+	responded := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		responded <- err
+	}()
+	<-started
+
+	// a mail send in flight
+	inFlight.Add(1)
+	workerStopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		shutdown(server, func() { close(workerStopped) })
+		close(done)
+	}()
+
+	// Test case 1
+	t.Run("waits for running requests", func(t *testing.T) {
+		select {
+		case <-done:
+			t.Fatal("Expected shutdown to wait for the running request")
+		case <-time.After(100 * time.Millisecond):
+		}
+		if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+			t.Error("Expected no new connections to be accepted")
+		}
+
+		close(release)
+		if err := <-responded; err != nil {
+			t.Errorf("Expected the running request to complete, but got: %v", err)
+		}
+	})
+
+	// Test case 2
+	t.Run("waits for work in flight", func(t *testing.T) {
+		select {
+		case <-done:
+			t.Fatal("Expected shutdown to wait for work in flight")
+		case <-time.After(100 * time.Millisecond):
+		}
+		select {
+		case <-workerStopped:
+		default:
+			t.Error("Expected the worker to be stopped")
+		}
+
+		inFlight.Done()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Expected shutdown to complete")
+		}
+	})
+
+	// restore log output to stdout
+	log.SetOutput(os.Stdout)
+}
+
+func TestProcessOneTimeLinkRequestsStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	returned := make(chan struct{})
+	go func() {
+		processOneTimeLinkRequests(ctx)
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Error("Expected the worker to return with an empty queue")
+	}
+}
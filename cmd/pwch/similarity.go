@@ -0,0 +1,102 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// checks whether the new password is merely a small variation of the
+// current one, e.g. "Winter2025!" followed by "Winter2026!"
+//
+// Both passwords are compared NFKC normalized and lower cased.
+func passwordsTooSimilar(oldPass, newPass, domain string) bool {
+	similarity := domainConfig(domain).PasswordPolicy.Similarity
+	oldRunes := []rune(strings.ToLower(norm.NFKC.String(oldPass)))
+	newRunes := []rune(strings.ToLower(norm.NFKC.String(newPass)))
+
+	if similarity.MinDistance > 0 && editDistance(oldRunes, newRunes) < similarity.MinDistance {
+		return true
+	}
+
+	if limit := similarity.MaxSharedAffix; limit > 0 {
+		if sharedPrefix(oldRunes, newRunes) > limit || sharedSuffix(oldRunes, newRunes) > limit {
+			return true
+		}
+	}
+
+	if similarity.DigitsOnly && withoutDigits(oldRunes) == withoutDigits(newRunes) {
+		return true
+	}
+	return false
+}
+
+// Levenshtein distance between a and b
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func sharedPrefix(a, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func sharedSuffix(a, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+func withoutDigits(runes []rune) string {
+	var b strings.Builder
+	for _, char := range runes {
+		if !unicode.IsDigit(char) {
+			b.WriteRune(char)
+		}
+	}
+	return b.String()
+}
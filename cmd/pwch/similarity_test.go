@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"kitten", "sitting", 3},
+		{"Winter2025!", "Winter2026!", 1},
+		{"", "abc", 3},
+	}
+
+	for _, test := range tests {
+		if got := editDistance([]rune(test.a), []rune(test.b)); got != test.distance {
+			t.Errorf("Expected distance %d between '%s' and '%s', but got: %d", test.distance, test.a, test.b, got)
+		}
+	}
+}
+
+func TestPasswordsTooSimilar(t *testing.T) {
+	testSimilar := func(t testing.TB, oldPass, newPass string, expected bool) {
+		t.Helper()
+
+		if got := passwordsTooSimilar(oldPass, newPass, "localdomain"); got != expected {
+			t.Errorf("Expected %t for '%s' and '%s', but got: %t", expected, oldPass, newPass, got)
+		}
+	}
+
+	// Test case 1
+	t.Run("edit distance", func(t *testing.T) {
+		cfg.PasswordPolicy.Similarity.MinDistance = 4
+		testSimilar(t, "Winter2025!", "Winter2026!", true)
+		testSimilar(t, "Winter2025!", "wINTER2025!", true)
+		testSimilar(t, "Winter2025!", "Summer2026?", false)
+		cfg.PasswordPolicy.Similarity.MinDistance = 0
+	})
+
+	// Test case 2
+	t.Run("shared prefix or suffix", func(t *testing.T) {
+		cfg.PasswordPolicy.Similarity.MaxSharedAffix = 6
+		testSimilar(t, "CorrectHorse1", "CorrectHorse-battery", true)
+		testSimilar(t, "1-battery-staple", "horse-battery-staple", true)
+		testSimilar(t, "CorrectHorse1", "Corral-battery", false)
+		cfg.PasswordPolicy.Similarity.MaxSharedAffix = 0
+	})
+
+	// Test case 3
+	t.Run("digit only changes", func(t *testing.T) {
+		cfg.PasswordPolicy.Similarity.DigitsOnly = true
+		testSimilar(t, "Spring2024!x", "Spring1999!x7", true)
+		testSimilar(t, "Spring2024!x", "Spring2024!y", false)
+		cfg.PasswordPolicy.Similarity.DigitsOnly = false
+	})
+
+	// Test case 4
+	t.Run("disabled", func(t *testing.T) {
+		testSimilar(t, "Winter2025!", "Winter2026!", false)
+	})
+}
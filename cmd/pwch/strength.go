@@ -0,0 +1,884 @@
+// Copyright (C) 2023  Benedikt Zumtobel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	_ "embed"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The strength estimator follows the ideas of zxcvbn
+// (https://github.com/dropbox/zxcvbn): the password is split into
+// guessable patterns like dictionary words, keyboard walks, repeats,
+// sequences and dates. The sequence of patterns needing the fewest
+// guesses determines the score.
+
+//go:embed wordlists/common_passwords.txt
+var commonPasswordsList string
+
+//go:embed wordlists/eff_large_wordlist.txt
+var effLargeWordlist string
+
+const (
+	strengthBruteforceCardinality = 10
+	strengthMinGuessesSingleChar  = 10
+	strengthMinGuessesMultiChar   = 50
+	// penalty for every additional pattern in a sequence
+	strengthMinGuessesBeforeGrowing = 10000
+	// only the beginning of a password is estimated, the matching is
+	// superlinear and anything longer is strong enough by its length
+	strengthMaxRunes = 100
+)
+
+// upper guess bounds of score 0 to 3, everything above scores 4
+var strengthScoreThresholds = []float64{1e3 + 5, 1e6 + 5, 1e8 + 5, 1e10 + 5}
+
+type rankedDictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+var strengthDictionaries = []rankedDictionary{
+	loadRankedDictionary("passwords", commonPasswordsList),
+	loadUniformDictionary("english", effLargeWordlist),
+}
+
+// common passwords are ranked by their line number
+func loadRankedDictionary(name, list string) rankedDictionary {
+	ranks := make(map[string]int)
+	for i, line := range strings.Split(list, "\n") {
+		word := strings.ToLower(strings.TrimSpace(line))
+		if _, ok := ranks[word]; word != "" && !ok {
+			ranks[word] = i + 1
+		}
+	}
+	return rankedDictionary{name: name, ranks: ranks}
+}
+
+// returns the words of a diceware list with "NNNNN<tab>word" lines
+func diceWords(list string) []string {
+	var words []string
+	for _, line := range strings.Split(list, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			words = append(words, strings.ToLower(fields[len(fields)-1]))
+		}
+	}
+	return words
+}
+
+// the EFF wordlist is sorted alphabetically, not by frequency,
+// so every word is ranked as if picked uniformly from the list
+func loadUniformDictionary(name, list string) rankedDictionary {
+	words := diceWords(list)
+	ranks := make(map[string]int, len(words))
+	for _, word := range words {
+		ranks[word] = len(words)
+	}
+	return rankedDictionary{name: name, ranks: ranks}
+}
+
+// characters commonly substituted for letters
+var l33tTable = map[rune][]rune{
+	'4': {'a'},
+	'@': {'a'},
+	'8': {'b'},
+	'(': {'c'},
+	'{': {'c'},
+	'[': {'c'},
+	'<': {'c'},
+	'3': {'e'},
+	'6': {'g'},
+	'9': {'g'},
+	'1': {'i', 'l'},
+	'!': {'i'},
+	'|': {'i', 'l'},
+	'7': {'l', 't'},
+	'0': {'o'},
+	'$': {'s'},
+	'5': {'s'},
+	'+': {'t'},
+	'%': {'x'},
+	'2': {'z'},
+}
+
+// qwerty layout, every row is shifted half a key to the right of the
+// row above, so a key's upper neighbours are at col and col+1
+var qwertyRows = [][2]string{
+	{"`1234567890-=", "~!@#$%^&*()_+"},
+	{" qwertyuiop[]\\", " QWERTYUIOP{}|"},
+	{" asdfghjkl;'", " ASDFGHJKL:\""},
+	{" zxcvbnm,./", " ZXCVBNM<>?"},
+}
+
+type keyPosition struct {
+	row, col int
+	shifted  bool
+}
+
+var qwertyKeys, qwertyStartingPositions, qwertyAverageDegree = loadQwertyGraph()
+
+// offsets of the six possible neighbours of a key
+var qwertyDirections = [][2]int{{0, -1}, {0, 1}, {-1, 0}, {-1, 1}, {1, -1}, {1, 0}}
+
+func loadQwertyGraph() (map[rune]keyPosition, float64, float64) {
+	keys := make(map[rune]keyPosition)
+	for row, chars := range qwertyRows {
+		for shifted, layer := range chars {
+			for col, char := range []rune(layer) {
+				if char != ' ' {
+					keys[char] = keyPosition{row: row, col: col, shifted: shifted == 1}
+				}
+			}
+		}
+	}
+
+	degrees := 0
+	for _, key := range keys {
+		for _, direction := range qwertyDirections {
+			if qwertyKeyAt(key.row+direction[0], key.col+direction[1]) {
+				degrees++
+			}
+		}
+	}
+	return keys, float64(len(keys)), float64(degrees) / float64(len(keys))
+}
+
+func qwertyKeyAt(row, col int) bool {
+	if row < 0 || row >= len(qwertyRows) {
+		return false
+	}
+	layer := []rune(qwertyRows[row][0])
+	return col >= 0 && col < len(layer) && layer[col] != ' '
+}
+
+// returns the direction from key a to key b or -1 if they aren't adjacent
+func qwertyDirection(a, b rune) int {
+	keyA, okA := qwertyKeys[a]
+	keyB, okB := qwertyKeys[b]
+	if !okA || !okB {
+		return -1
+	}
+	for i, direction := range qwertyDirections {
+		if keyA.row+direction[0] == keyB.row && keyA.col+direction[1] == keyB.col {
+			return i
+		}
+	}
+	return -1
+}
+
+// a guessable part of the password
+type strengthMatch struct {
+	pattern string
+	i, j    int
+	token   string
+	guesses float64
+
+	// dictionary
+	dictionary string
+	rank       int
+	reversed   bool
+	l33t       bool
+	// spatial
+	turns int
+	// repeat
+	baseToken string
+	// date
+	year int
+}
+
+type strengthEstimate struct {
+	Guesses     float64
+	Score       int
+	Warnings    []string
+	Suggestions []string
+}
+
+// estimates how hard the password is to guess, userInputs are
+// additional words like the username that are easy to guess
+func estimatePasswordStrength(password string, userInputs ...string) strengthEstimate {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return strengthEstimate{Suggestions: []string{"Use a few words, avoid common phrases"}}
+	}
+	if len(runes) > strengthMaxRunes {
+		runes = runes[:strengthMaxRunes]
+	}
+
+	dictionaries := strengthDictionaries
+	if len(userInputs) > 0 {
+		dictionaries = append([]rankedDictionary{userInputDictionary(userInputs)}, dictionaries...)
+	}
+
+	guesses, sequence := mostGuessableSequence(runes, findStrengthMatches(runes, dictionaries))
+
+	estimate := strengthEstimate{Guesses: guesses, Score: len(strengthScoreThresholds)}
+	for score, threshold := range strengthScoreThresholds {
+		if guesses < threshold {
+			estimate.Score = score
+			break
+		}
+	}
+	estimate.Warnings, estimate.Suggestions = strengthFeedback(estimate.Score, sequence)
+	return estimate
+}
+
+func userInputDictionary(inputs []string) rankedDictionary {
+	ranks := make(map[string]int)
+	for i, input := range inputs {
+		input = strings.ToLower(input)
+		if _, ok := ranks[input]; input != "" && !ok {
+			ranks[input] = i + 1
+		}
+	}
+	return rankedDictionary{name: "user_inputs", ranks: ranks}
+}
+
+func findStrengthMatches(runes []rune, dictionaries []rankedDictionary) []strengthMatch {
+	var matches []strengthMatch
+	matches = append(matches, dictionaryMatches(runes, dictionaries)...)
+	matches = append(matches, reversedDictionaryMatches(runes, dictionaries)...)
+	matches = append(matches, l33tMatches(runes, dictionaries)...)
+	matches = append(matches, spatialMatches(runes)...)
+	matches = append(matches, repeatMatches(runes, dictionaries)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	return matches
+}
+
+//
+// matchers
+//
+
+func dictionaryMatches(runes []rune, dictionaries []rankedDictionary) []strengthMatch {
+	lower := []rune(strings.ToLower(string(runes)))
+
+	var matches []strengthMatch
+	for i := range lower {
+		for j := i; j < len(lower); j++ {
+			word := string(lower[i : j+1])
+			for _, dictionary := range dictionaries {
+				rank, ok := dictionary.ranks[word]
+				if !ok {
+					continue
+				}
+				token := string(runes[i : j+1])
+				matches = append(matches, strengthMatch{
+					pattern:    "dictionary",
+					i:          i,
+					j:          j,
+					token:      token,
+					dictionary: dictionary.name,
+					rank:       rank,
+					guesses:    float64(rank) * uppercaseVariations(token),
+				})
+			}
+		}
+	}
+	return matches
+}
+
+func reversedDictionaryMatches(runes []rune, dictionaries []rankedDictionary) []strengthMatch {
+	reversed := make([]rune, len(runes))
+	for i, char := range runes {
+		reversed[len(runes)-1-i] = char
+	}
+
+	var matches []strengthMatch
+	for _, match := range dictionaryMatches(reversed, dictionaries) {
+		// ignore palindromes, they are covered by the normal dictionary match
+		if len([]rune(match.token)) < 2 || match.token == reverseString(match.token) {
+			continue
+		}
+		match.i, match.j = len(runes)-1-match.j, len(runes)-1-match.i
+		match.token = string(runes[match.i : match.j+1])
+		match.reversed = true
+		match.guesses *= 2
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func reverseString(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// maximum number of ambiguous substitutions tried per substring
+const maxL33tCombinations = 64
+
+func l33tMatches(runes []rune, dictionaries []rankedDictionary) []strengthMatch {
+	var matches []strengthMatch
+	for i := range runes {
+		for j := i + 1; j < len(runes); j++ {
+			token := runes[i : j+1]
+
+			var positions []int
+			combinations := 1
+			for k, char := range token {
+				if subs, ok := l33tTable[char]; ok {
+					positions = append(positions, k)
+					combinations *= len(subs)
+				}
+			}
+			if len(positions) == 0 || combinations > maxL33tCombinations {
+				continue
+			}
+
+			for combination := 0; combination < combinations; combination++ {
+				translated := []rune(strings.ToLower(string(token)))
+				subbed := make(map[rune]rune)
+				c := combination
+				for _, k := range positions {
+					subs := l33tTable[token[k]]
+					translated[k] = subs[c%len(subs)]
+					subbed[token[k]] = subs[c%len(subs)]
+					c /= len(subs)
+				}
+
+				word := string(translated)
+				for _, dictionary := range dictionaries {
+					rank, ok := dictionary.ranks[word]
+					if !ok {
+						continue
+					}
+					matches = append(matches, strengthMatch{
+						pattern:    "dictionary",
+						i:          i,
+						j:          j,
+						token:      string(token),
+						dictionary: dictionary.name,
+						rank:       rank,
+						l33t:       true,
+						guesses:    float64(rank) * uppercaseVariations(string(token)) * l33tVariations(token, subbed),
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+func spatialMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i < len(runes)-2; {
+		j := i
+		turns := 0
+		lastDirection := -1
+		for j+1 < len(runes) {
+			direction := qwertyDirection(runes[j], runes[j+1])
+			if direction == -1 {
+				break
+			}
+			if direction != lastDirection {
+				turns++
+				lastDirection = direction
+			}
+			j++
+		}
+
+		if j-i+1 >= 3 {
+			shifted := 0
+			for _, char := range runes[i : j+1] {
+				if qwertyKeys[char].shifted {
+					shifted++
+				}
+			}
+			matches = append(matches, strengthMatch{
+				pattern: "spatial",
+				i:       i,
+				j:       j,
+				token:   string(runes[i : j+1]),
+				turns:   turns,
+				guesses: spatialGuesses(j-i+1, turns, shifted),
+			})
+			i = j + 1
+		} else {
+			i++
+		}
+	}
+	return matches
+}
+
+func spatialGuesses(length, turns, shifted int) float64 {
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= turns && j <= i-1; j++ {
+			guesses += binomial(i-1, j-1) * qwertyStartingPositions * math.Pow(qwertyAverageDegree, float64(j))
+		}
+	}
+
+	unshifted := length - shifted
+	if shifted > 0 {
+		if unshifted == 0 {
+			guesses *= 2
+		} else {
+			variations := 0.0
+			for i := 1; i <= shifted && i <= unshifted; i++ {
+				variations += binomial(shifted+unshifted, i)
+			}
+			guesses *= variations
+		}
+	}
+	return guesses
+}
+
+func repeatMatches(runes []rune, dictionaries []rankedDictionary) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i < len(runes); {
+		best := strengthMatch{}
+		for base := 1; i+2*base <= len(runes); base++ {
+			baseToken := string(runes[i : i+base])
+			repeats := 1
+			for i+(repeats+1)*base <= len(runes) && string(runes[i+repeats*base:i+(repeats+1)*base]) == baseToken {
+				repeats++
+			}
+			if repeats < 2 || repeats*base <= best.j-best.i+1 {
+				continue
+			}
+
+			baseRunes := runes[i : i+base]
+			baseGuesses, _ := mostGuessableSequence(baseRunes, findStrengthMatches(baseRunes, dictionaries))
+			best = strengthMatch{
+				pattern:   "repeat",
+				i:         i,
+				j:         i + repeats*base - 1,
+				token:     string(runes[i : i+repeats*base]),
+				baseToken: baseToken,
+				guesses:   baseGuesses * float64(repeats),
+			}
+		}
+
+		if best.pattern == "" {
+			i++
+			continue
+		}
+		matches = append(matches, best)
+		i = best.j + 1
+	}
+	return matches
+}
+
+func sequenceMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i < len(runes)-2; {
+		delta := int(runes[i+1]) - int(runes[i])
+		j := i + 1
+		for j+1 < len(runes) && int(runes[j+1])-int(runes[j]) == delta {
+			j++
+		}
+
+		if j-i+1 >= 3 && delta != 0 && delta >= -5 && delta <= 5 {
+			first := runes[i]
+			base := 26.0
+			switch {
+			case strings.ContainsRune("aAzZ019", first):
+				base = 4
+			case unicode.IsDigit(first):
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches, strengthMatch{
+				pattern: "sequence",
+				i:       i,
+				j:       j,
+				token:   string(runes[i : j+1]),
+				guesses: base * float64(j-i+1),
+			})
+			i = j
+		} else {
+			i++
+		}
+	}
+	return matches
+}
+
+var (
+	yearPattern          = regexp.MustCompile(`^(19|20)\d\d$`)
+	dateSeparatedPattern = regexp.MustCompile(`^(\d{1,4})([\s/\\_.-])(\d{1,2})([\s/\\_.-])(\d{1,4})$`)
+	digitsPattern        = regexp.MustCompile(`^\d{4,8}$`)
+)
+
+const strengthMinYearSpace = 20
+
+func dateMatches(runes []rune) []strengthMatch {
+	referenceYear := time.Now().Year()
+
+	var matches []strengthMatch
+	for i := range runes {
+		for j := i + 3; j < len(runes) && j-i < 10; j++ {
+			token := string(runes[i : j+1])
+
+			if yearPattern.MatchString(token) {
+				year, _ := strconv.Atoi(token)
+				matches = append(matches, strengthMatch{
+					pattern: "year",
+					i:       i,
+					j:       j,
+					token:   token,
+					year:    year,
+					guesses: yearSpace(year, referenceYear),
+				})
+			}
+
+			var year int
+			var ok, separated bool
+			if parts := dateSeparatedPattern.FindStringSubmatch(token); parts != nil && parts[2] == parts[4] {
+				year, ok = parseDate(parts[1], parts[3], parts[5])
+				separated = true
+			} else if digitsPattern.MatchString(token) && len(token) != 4 {
+				year, ok = parseUnseparatedDate(token)
+			}
+			if !ok {
+				continue
+			}
+
+			guesses := 365 * yearSpace(year, referenceYear)
+			if separated {
+				guesses *= 4
+			}
+			matches = append(matches, strengthMatch{
+				pattern: "date",
+				i:       i,
+				j:       j,
+				token:   token,
+				year:    year,
+				guesses: guesses,
+			})
+		}
+	}
+	return matches
+}
+
+func yearSpace(year, referenceYear int) float64 {
+	space := math.Abs(float64(year - referenceYear))
+	return math.Max(space, strengthMinYearSpace)
+}
+
+// tries every split of a digit string into day, month and year
+func parseUnseparatedDate(token string) (int, bool) {
+	for _, split := range [][2]int{{1, 2}, {2, 3}, {2, 4}, {4, 5}, {4, 6}, {1, 3}, {2, len(token) - 4}} {
+		a, b := split[0], split[1]
+		if a >= b || b >= len(token) {
+			continue
+		}
+		if year, ok := parseDate(token[:a], token[a:b], token[b:]); ok {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+// accepts year-month-day, day-month-year and month-day-year orders
+func parseDate(first, second, third string) (int, bool) {
+	a, _ := strconv.Atoi(first)
+	b, _ := strconv.Atoi(second)
+	c, _ := strconv.Atoi(third)
+
+	validDay := func(d int) bool { return d >= 1 && d <= 31 }
+	validMonth := func(m int) bool { return m >= 1 && m <= 12 }
+	year := func(y string, value int) (int, bool) {
+		switch len(y) {
+		case 2:
+			if value > 50 {
+				return 1900 + value, true
+			}
+			return 2000 + value, true
+		case 4:
+			return value, value >= 1000 && value <= 2050
+		}
+		return 0, false
+	}
+
+	if y, ok := year(first, a); ok && validMonth(b) && validDay(c) {
+		return y, true
+	}
+	if y, ok := year(third, c); ok {
+		if (validDay(a) && validMonth(b)) || (validMonth(a) && validDay(b)) {
+			return y, true
+		}
+	}
+	return 0, false
+}
+
+//
+// guess estimation
+//
+
+func uppercaseVariations(token string) float64 {
+	upper, lower := 0, 0
+	for _, char := range token {
+		if unicode.IsUpper(char) {
+			upper++
+		} else if unicode.IsLower(char) {
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+
+	runes := []rune(token)
+	startUpper := unicode.IsUpper(runes[0]) && upper == 1
+	endUpper := unicode.IsUpper(runes[len(runes)-1]) && upper == 1
+	if startUpper || endUpper || lower == 0 {
+		return 2
+	}
+
+	variations := 0.0
+	for i := 1; i <= upper && i <= lower; i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return variations
+}
+
+func l33tVariations(token []rune, subbed map[rune]rune) float64 {
+	variations := 1.0
+	lower := []rune(strings.ToLower(string(token)))
+	for sub, letter := range subbed {
+		s, u := 0, 0
+		for _, char := range lower {
+			if char == sub {
+				s++
+			} else if char == letter {
+				u++
+			}
+		}
+		if s == 0 || u == 0 {
+			variations *= 2
+			continue
+		}
+		possibilities := 0.0
+		for i := 1; i <= s && i <= u; i++ {
+			possibilities += binomial(s+u, i)
+		}
+		variations *= possibilities
+	}
+	return variations
+}
+
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	result := 1.0
+	for d := 1; d <= k; d++ {
+		result *= float64(n - k + d)
+		result /= float64(d)
+	}
+	return result
+}
+
+func factorial(n int) float64 {
+	result := 1.0
+	for i := 2; i <= n; i++ {
+		result *= float64(i)
+	}
+	return result
+}
+
+func bruteforceMatch(runes []rune, i, j int) strengthMatch {
+	guesses := math.Pow(strengthBruteforceCardinality, float64(j-i+1))
+	if j == i {
+		guesses = math.Max(guesses, strengthMinGuessesSingleChar+1)
+	} else {
+		guesses = math.Max(guesses, strengthMinGuessesMultiChar+1)
+	}
+	return strengthMatch{pattern: "bruteforce", i: i, j: j, token: string(runes[i : j+1]), guesses: guesses}
+}
+
+// finds the sequence of non-overlapping matches covering the password
+// that minimizes l! * product(guesses) + D^(l-1), l being the number of
+// matches. Gaps are filled with bruteforce matches.
+func mostGuessableSequence(runes []rune, matches []strengthMatch) (float64, []strengthMatch) {
+	n := len(runes)
+	if n == 0 {
+		return 1, nil
+	}
+
+	byEnd := make([][]strengthMatch, n)
+	for _, match := range matches {
+		// matches not covering the whole password need a minimum
+		// amount of guesses, otherwise "a" would be cheaper than bruteforce
+		if match.j-match.i+1 < n {
+			minimum := float64(strengthMinGuessesMultiChar)
+			if match.i == match.j {
+				minimum = strengthMinGuessesSingleChar
+			}
+			match.guesses = math.Max(match.guesses, minimum)
+		}
+		byEnd[match.j] = append(byEnd[match.j], match)
+	}
+
+	type step struct {
+		match strengthMatch
+		pi    float64
+		g     float64
+	}
+	// optimal[k][l] is the best sequence of length l ending at k
+	optimal := make([]map[int]step, n)
+	for k := range optimal {
+		optimal[k] = make(map[int]step)
+	}
+
+	update := func(match strengthMatch, l int) {
+		k := match.j
+		pi := match.guesses
+		if l > 1 {
+			pi *= optimal[match.i-1][l-1].pi
+		}
+		g := factorial(l)*pi + math.Pow(strengthMinGuessesBeforeGrowing, float64(l-1))
+
+		for competingL, competing := range optimal[k] {
+			if competingL <= l && competing.g <= g {
+				return
+			}
+		}
+		optimal[k][l] = step{match: match, pi: pi, g: g}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, match := range byEnd[k] {
+			if match.i > 0 {
+				for l := range optimal[match.i-1] {
+					update(match, l+1)
+				}
+			} else {
+				update(match, 1)
+			}
+		}
+
+		update(bruteforceMatch(runes, 0, k), 1)
+		for i := 1; i <= k; i++ {
+			for l, last := range optimal[i-1] {
+				// adjacent bruteforce matches are never better than a single one
+				if last.match.pattern == "bruteforce" {
+					continue
+				}
+				update(bruteforceMatch(runes, i, k), l+1)
+			}
+		}
+	}
+
+	bestL := 0
+	bestG := math.Inf(1)
+	for l, candidate := range optimal[n-1] {
+		if candidate.g < bestG || (candidate.g == bestG && l < bestL) {
+			bestL, bestG = l, candidate.g
+		}
+	}
+
+	sequence := make([]strengthMatch, bestL)
+	k := n - 1
+	for l := bestL; l > 0; l-- {
+		match := optimal[k][l].match
+		sequence[l-1] = match
+		k = match.i - 1
+	}
+	return bestG, sequence
+}
+
+//
+// feedback
+//
+
+func strengthFeedback(score int, sequence []strengthMatch) ([]string, []string) {
+	if score > 2 {
+		return nil, nil
+	}
+
+	var warnings, suggestions []string
+	add := func(list *[]string, message string) {
+		for _, existing := range *list {
+			if existing == message {
+				return
+			}
+		}
+		*list = append(*list, message)
+	}
+
+	add(&suggestions, "Add another word or two. Uncommon words are better.")
+	for _, match := range sequence {
+		soleMatch := len(sequence) == 1
+
+		switch match.pattern {
+		case "dictionary":
+			switch {
+			case match.dictionary == "passwords" && soleMatch && !match.l33t && !match.reversed && match.rank <= 10:
+				add(&warnings, "This is a top-10 common password")
+			case match.dictionary == "passwords" && soleMatch && !match.l33t && !match.reversed && match.rank <= 100:
+				add(&warnings, "This is a top-100 common password")
+			case match.dictionary == "passwords" && soleMatch && !match.l33t && !match.reversed:
+				add(&warnings, "This is a very common password")
+			case match.dictionary == "passwords":
+				add(&warnings, "This is similar to a commonly used password")
+			case match.dictionary == "user_inputs":
+				add(&warnings, "Parts of your email address are easy to guess")
+			case soleMatch:
+				add(&warnings, "A word by itself is easy to guess")
+			default:
+				add(&warnings, "Common words are easy to guess")
+			}
+
+			token := []rune(match.token)
+			if strings.ToUpper(match.token) == match.token && strings.ToLower(match.token) != match.token {
+				add(&suggestions, "All-uppercase is almost as easy to guess as all-lowercase")
+			} else if unicode.IsUpper(token[0]) {
+				add(&suggestions, "Capitalization doesn't help very much")
+			}
+			if match.reversed && len(token) >= 4 {
+				add(&suggestions, "Reversed words aren't much harder to guess")
+			}
+			if match.l33t {
+				add(&suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+			}
+		case "spatial":
+			if match.turns == 1 {
+				add(&warnings, "Straight rows of keys are easy to guess")
+			} else {
+				add(&warnings, "Short keyboard patterns are easy to guess")
+			}
+			add(&suggestions, "Use a longer keyboard pattern with more turns")
+		case "repeat":
+			if len([]rune(match.baseToken)) == 1 {
+				add(&warnings, "Repeats like \"aaa\" are easy to guess")
+			} else {
+				add(&warnings, "Repeats like \"abcabcabc\" are only slightly harder to guess than \"abc\"")
+			}
+			add(&suggestions, "Avoid repeated words and characters")
+		case "sequence":
+			add(&warnings, "Sequences like abc or 6543 are easy to guess")
+			add(&suggestions, "Avoid sequences")
+		case "year":
+			add(&warnings, "Recent years are easy to guess")
+			add(&suggestions, "Avoid recent years and years that are associated with you")
+		case "date":
+			add(&warnings, "Dates are often easy to guess")
+			add(&suggestions, "Avoid dates and years that are associated with you")
+		}
+	}
+	return warnings, suggestions
+}
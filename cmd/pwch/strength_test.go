@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	testStrength := func(t testing.TB, password string, maxScore int, expectedWarning string) {
+		t.Helper()
+
+		estimate := estimatePasswordStrength(password)
+
+		if estimate.Score > maxScore {
+			t.Errorf("Expected score of at most %d for '%s', but got: %d", maxScore, password, estimate.Score)
+		}
+
+		if !strings.Contains(strings.Join(estimate.Warnings, "\n"), expectedWarning) {
+			t.Errorf("Expected warning '%s' for '%s', but got: %v", expectedWarning, password, estimate.Warnings)
+		}
+	}
+
+	// Test case 1
+	t.Run("common password", func(t *testing.T) {
+		testStrength(t, "password", 0, "This is a top-10 common password")
+	})
+
+	// Test case 2
+	t.Run("l33t substitution", func(t *testing.T) {
+		testStrength(t, "P@ssw0rd", 0, "This is similar to a commonly used password")
+
+		estimate := estimatePasswordStrength("P@ssw0rd")
+		if !strings.Contains(strings.Join(estimate.Suggestions, "\n"), "Predictable substitutions") {
+			t.Errorf("Expected l33t suggestion, but got: %v", estimate.Suggestions)
+		}
+	})
+
+	// Test case 3
+	t.Run("keyboard pattern", func(t *testing.T) {
+		testStrength(t, "zxcvfr", 1, "keyboard patterns are easy to guess")
+	})
+
+	// Test case 4
+	t.Run("repeated characters", func(t *testing.T) {
+		testStrength(t, "aaaaaaaaaa", 0, "Repeats like \"aaa\" are easy to guess")
+	})
+
+	// Test case 5
+	t.Run("sequence", func(t *testing.T) {
+		testStrength(t, "abcdefgh", 0, "Sequences like abc or 6543 are easy to guess")
+	})
+
+	// Test case 6
+	t.Run("date", func(t *testing.T) {
+		testStrength(t, "13.05.1987", 1, "Dates are often easy to guess")
+	})
+
+	// Test case 7
+	t.Run("dictionary word", func(t *testing.T) {
+		testStrength(t, "Abdomen", 1, "A word by itself is easy to guess")
+	})
+
+	// Test case 8
+	t.Run("user input", func(t *testing.T) {
+		estimate := estimatePasswordStrength("alice", "alice", "example")
+		if estimate.Score != 0 {
+			t.Errorf("Expected score 0, but got: %d", estimate.Score)
+		}
+	})
+
+	// Test case 9
+	t.Run("strong passwords", func(t *testing.T) {
+		for _, password := range []string{"kX9#mQ2$vL7!", "correct-horse-battery-staple"} {
+			estimate := estimatePasswordStrength(password)
+			if estimate.Score < 4 {
+				t.Errorf("Expected score 4 for '%s', but got: %d", password, estimate.Score)
+			}
+			if len(estimate.Warnings) > 0 {
+				t.Errorf("Expected no warnings for '%s', but got: %v", password, estimate.Warnings)
+			}
+		}
+	})
+
+	// Test case 10
+	t.Run("long password", func(t *testing.T) {
+		start := time.Now()
+		estimatePasswordStrength(strings.Repeat("correct-horse-battery-staple-", 150))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected estimate to finish within a second, but took: %v", elapsed)
+		}
+	})
+}
+
+func TestStrengthPasswordPolicy(t *testing.T) {
+	cfg.PasswordPolicy.MinLength = 6
+	cfg.PasswordPolicy.MaxLength = 64
+	cfg.PasswordPolicy.LowerCase = false
+	cfg.PasswordPolicy.UpperCase = false
+	cfg.PasswordPolicy.Digits = false
+	cfg.PasswordPolicy.SepcialChar = false
+	cfg.PasswordPolicy.MinScore = 3
+
+	// Test case 1
+	t.Run("weak password", func(t *testing.T) {
+		valid, message := enforcePasswordPolicy("qwerty123", "", "")
+		if valid {
+			t.Error("Expected weak password to be invalid")
+		}
+		if !strings.HasPrefix(message, "Please choose a password that is harder to guess\n") {
+			t.Errorf("Unexpected error message: %s", message)
+		}
+	})
+
+	// Test case 2
+	t.Run("strong password without character classes", func(t *testing.T) {
+		valid, message := enforcePasswordPolicy("correct-horse-battery-staple", "", "")
+		if !valid {
+			t.Errorf("Expected strong password to be valid, but got: %s", message)
+		}
+	})
+
+	cfg.PasswordPolicy.MinScore = 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenFDs(t *testing.T) {
+	check := func(t testing.TB, pid, fds string, expected int, expectError bool) {
+		t.Helper()
+
+		t.Setenv("LISTEN_PID", pid)
+		t.Setenv("LISTEN_FDS", fds)
+		count, err := listenFDs()
+		if count != expected || (err != nil) != expectError {
+			t.Errorf("Expected %d sockets, but got: %d, %v", expected, count, err)
+		}
+	}
+
+	// Test case 1
+	t.Run("sockets for this process", func(t *testing.T) {
+		check(t, strconv.Itoa(os.Getpid()), "2", 2, false)
+	})
+
+	// Test case 2
+	t.Run("sockets for another process", func(t *testing.T) {
+		check(t, "1", "2", 0, false)
+	})
+
+	// Test case 3
+	t.Run("not socket activated", func(t *testing.T) {
+		check(t, "", "", 0, false)
+	})
+
+	// Test case 4
+	t.Run("invalid count", func(t *testing.T) {
+		check(t, strconv.Itoa(os.Getpid()), "many", 0, true)
+	})
+}
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Test case 1
+	t.Run("state is sent to the socket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", path)
+		if err := sdNotify("READY=1"); err != nil {
+			t.Fatalf("Expected error to be nil, but got: %v", err)
+		}
+
+		buf := make([]byte, 64)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "READY=1" {
+			t.Errorf("Expected READY=1, but got: %q, %v", buf[:n], err)
+		}
+	})
+
+	// Test case 2
+	t.Run("without systemd", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		if err := sdNotify("READY=1"); err != nil {
+			t.Errorf("Expected error to be nil, but got: %v", err)
+		}
+	})
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+
+	// Test case 1
+	t.Run("half of WatchdogSec", func(t *testing.T) {
+		t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+		if interval := watchdogInterval(); interval != 15*time.Second {
+			t.Errorf("Expected 15s, but got: %v", interval)
+		}
+	})
+
+	// Test case 2
+	t.Run("watchdog of another process", func(t *testing.T) {
+		t.Setenv("WATCHDOG_PID", "1")
+		if interval := watchdogInterval(); interval != 0 {
+			t.Errorf("Expected watchdog to be disabled, but got: %v", interval)
+		}
+	})
+}
+
+func TestServesRequests(t *testing.T) {
+	// Test case 1
+	t.Run("answered request", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("{}"))
+		})
+		if !servesRequests(handler, "/api/policy", time.Second) {
+			t.Error("Expected check to pass")
+		}
+	})
+
+	// Test case 2
+	t.Run("failed request", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		})
+		if servesRequests(handler, "/api/policy", time.Second) {
+			t.Error("Expected check to fail")
+		}
+	})
+
+	// Test case 3
+	t.Run("hanging handler", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		})
+		if servesRequests(handler, "/api/policy", 10*time.Millisecond) {
+			t.Error("Expected check to fail")
+		}
+	})
+}
@@ -27,12 +27,35 @@ CREATE TABLE IF NOT EXISTS accounts (
     quota int check (quota > 0) DEFAULT '0',
     enabled boolean DEFAULT '0',
     sendonly boolean DEFAULT '0',
+    password_changed_at timestamptz NOT NULL DEFAULT now(),
+    password_reminder_sent_at timestamptz,
+    must_change_password boolean NOT NULL DEFAULT false,
     PRIMARY KEY (id),
     UNIQUE (username, domain),
     FOREIGN KEY (domain) REFERENCES domains (domain)
 );
 
+CREATE SEQUENCE IF NOT EXISTS password_history_seq
+    INCREMENT BY 1
+    NO MAXVALUE
+    NO MINVALUE
+    CACHE 1;
+
+CREATE TABLE IF NOT EXISTS password_history (
+    id int check (id > 0) NOT NULL DEFAULT NEXTVAL ('password_history_seq'),
+    username varchar(64) NOT NULL,
+    domain varchar(255) NOT NULL,
+    password varchar(255) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (id),
+    FOREIGN KEY (username, domain) REFERENCES accounts (username, domain) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS password_history_account_idx ON password_history (username, domain, created_at);
+
 ALTER TABLE domains OWNER TO <YOUR_POSTGRES_USER>;
 ALTER TABLE accounts OWNER TO <YOUR_POSTGRES_USER>;
+ALTER TABLE password_history OWNER TO <YOUR_POSTGRES_USER>;
 ALTER SEQUENCE domains_seq OWNER TO <YOUR_POSTGRES_USER>;
 ALTER SEQUENCE accounts_seq OWNER TO <YOUR_POSTGRES_USER>;
+ALTER SEQUENCE password_history_seq OWNER TO <YOUR_POSTGRES_USER>;
@@ -4,7 +4,9 @@ go 1.20
 
 require (
 	github.com/lib/pq v1.10.9
+	github.com/rivo/uniseg v0.4.4
 	golang.org/x/crypto v0.11.0
+	golang.org/x/text v0.13.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 